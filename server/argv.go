@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// splitArgv splits a command into its arguments. Arguments are separated
+// by whitespace, and can be quoted using single or double quotes or
+// escaped using backslashes to contain whitespace.
+func splitArgv(cmd string) ([]string, error) {
+	result := []string{}
+	var current strings.Builder
+	inArg := false
+
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+
+		switch {
+		case c == '\'':
+			end := strings.IndexByte(cmd[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+
+			current.WriteString(cmd[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(cmd) && cmd[i] != '"'; i++ {
+				if cmd[i] == '\\' && i+1 < len(cmd) && (cmd[i+1] == '"' || cmd[i+1] == '\\') {
+					i++
+				}
+
+				current.WriteByte(cmd[i])
+			}
+
+			if i >= len(cmd) {
+				return nil, errors.New("unterminated double quote")
+			}
+
+			inArg = true
+		case c == '\\':
+			if i+1 >= len(cmd) {
+				return nil, errors.New("trailing backslash")
+			}
+
+			i++
+			current.WriteByte(cmd[i])
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				result = append(result, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		result = append(result, current.String())
+	}
+
+	return result, nil
+}
+
+// joinArgv turns a list of arguments back into the line that is sent to
+// the dokku daemon.
+// NOTE(happens): We can't rely on the daemon understanding any kind of
+// quoting, so arguments are joined as they are. Since they can't be
+// empty or contain whitespace, splitting the line at whitespace always
+// gives back exactly the same arguments.
+func joinArgv(argv []string) string {
+	return strings.Join(argv, " ")
+}
+
+// checkArg makes sure that an argument of a command is sent to the
+// daemon as exactly one argument.
+func checkArg(arg string) error {
+	if len(arg) == 0 {
+		return errors.New("arguments can't be empty")
+	}
+
+	if strings.IndexFunc(arg, unicode.IsSpace) >= 0 {
+		e := fmt.Sprintf("argument %q contains whitespace, which can't be sent to the dokku daemon as a single argument", arg)
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+// formatArgv describes every argument of a command on its own, so
+// that it is clear where one argument ends and the next begins.
+func formatArgv(argv []string) string {
+	quoted := []string{}
+	for _, arg := range argv {
+		quoted = append(quoted, strconv.Quote(arg))
+	}
+
+	return fmt.Sprintf("[%s]", strings.Join(quoted, ", "))
+}
+
+// shellMetaChars could change the meaning of a command if the daemon
+// passes it through a shell, so they are never allowed in parameter values
+const shellMetaChars = ";|&$`<>()'\"\\*?["
+
+// checkParamValue makes sure that a parameter value can't be used to
+// pass additional arguments or run other commands. Whitespace would
+// split the argument, and line breaks and other control characters
+// would end the command.
+// NOTE(happens): Rejecting anything a shell would interpret is safe
+// regardless of what the daemon does with the line, while quoting
+// might not be.
+func checkParamValue(value string) error {
+	for _, r := range value {
+		switch {
+		case r == '\n' || r == '\r':
+			return errors.New("must not contain line breaks")
+		case unicode.IsSpace(r):
+			return errors.New("must not contain whitespace")
+		case unicode.IsControl(r):
+			return errors.New("must not contain control characters")
+		case strings.ContainsRune(shellMetaChars, r):
+			e := fmt.Sprintf("must not contain any of %s", shellMetaChars)
+			return errors.New(e)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgv(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		want []string
+	}{
+		{"", []string{}},
+		{"ps:restart #app", []string{"ps:restart", "#app"}},
+		{"  a \t b\n", []string{"a", "b"}},
+		{"config:set #app 'MSG=#msg'", []string{"config:set", "#app", "MSG=#msg"}},
+		{`config:set #app "MSG=a b"`, []string{"config:set", "#app", "MSG=a b"}},
+		{`a "say \"hi\"" 'it''s'`, []string{"a", `say "hi"`, "its"}},
+		{`a\ b c`, []string{"a b", "c"}},
+		{`a "" ''`, []string{"a", "", ""}},
+		{`a "x\y"`, []string{"a", `x\y`}},
+	}
+
+	for _, c := range cases {
+		got, err := splitArgv(c.cmd)
+		if err != nil {
+			t.Errorf("splitArgv(%q) failed: %v", c.cmd, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("splitArgv(%q) = %q, want %q", c.cmd, got, c.want)
+		}
+	}
+}
+
+func TestSplitArgvErrors(t *testing.T) {
+	cases := []string{
+		"a 'b",
+		`a "b`,
+		`a "b\"`,
+		`a \`,
+	}
+
+	for _, cmd := range cases {
+		if _, err := splitArgv(cmd); err == nil {
+			t.Errorf("splitArgv(%q) should fail", cmd)
+		}
+	}
+}
+
+func TestJoinArgv(t *testing.T) {
+	cases := [][]string{
+		{"ps:restart", "app"},
+		{"config:set", "app", "MSG=hello"},
+		{"a", "it's", `say"hi"`, `back\slash`, "#app"},
+	}
+
+	// NOTE(happens): The daemon is only guaranteed to split the line at
+	// whitespace, so that has to give back the same arguments
+	for _, argv := range cases {
+		for _, arg := range argv {
+			if err := checkArg(arg); err != nil {
+				t.Fatalf("checkArg(%q) failed: %v", arg, err)
+			}
+		}
+
+		if got := strings.Fields(joinArgv(argv)); !reflect.DeepEqual(got, argv) {
+			t.Errorf("strings.Fields(joinArgv(%q)) = %q", argv, got)
+		}
+	}
+}
+
+func TestCheckArg(t *testing.T) {
+	cases := []string{"", "a b", "a\tb", "a\nb", "a\u00a0b"}
+
+	for _, arg := range cases {
+		if err := checkArg(arg); err == nil {
+			t.Errorf("checkArg(%q) should fail", arg)
+		}
+	}
+}
+
+func TestSetCommandsWhitespace(t *testing.T) {
+	cases := []string{
+		`config:set #app "MSG=a b"`,
+		`config:set #app 'MSG=a b'`,
+		`config:set #app MSG=a\ b`,
+		`config:set #app ''`,
+	}
+
+	for _, cmd := range cases {
+		var hook hookData
+		if err := hook.SetCommands(cmd); err == nil {
+			t.Errorf("SetCommands(%q) should fail", cmd)
+		}
+	}
+}
+
+func TestCheckParamValue(t *testing.T) {
+	cases := []struct {
+		value string
+		ok    bool
+	}{
+		{"v1.2.3", true},
+		{"feature/foo-bar_baz", true},
+		{"key=value,other@host:5000", true},
+		{"", true},
+		{"a b", false},
+		{"a b", false},
+		{"a\nb", false},
+		{"a\rb", false},
+		{"a\x00b", false},
+		{"a\x1bb", false},
+
+		// NOTE(happens): Values must not be able to run other
+		// commands, whatever the daemon does with the line
+		{"x;rm${IFS}-rf", false},
+		{"$(id)", false},
+		{"`id`", false},
+		{"a|b", false},
+		{"a&&b", false},
+		{"a&", false},
+		{"a>/etc/passwd", false},
+		{"a</etc/passwd", false},
+		{"$HOME", false},
+		{"'a", false},
+		{`"a`, false},
+		{`a\`, false},
+		{"*", false},
+		{"a?", false},
+		{"[ab]", false},
+		{"a; reboot", false},
+	}
+
+	for _, c := range cases {
+		err := checkParamValue(c.value)
+		if c.ok && err != nil {
+			t.Errorf("checkParamValue(%q) failed: %v", c.value, err)
+		}
+
+		if !c.ok && err == nil {
+			t.Errorf("checkParamValue(%q) should fail", c.value)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// callbackAttempts is the number of times a callback is sent
+	// before giving up, waiting callbackBackoff before the first
+	// retry and doubling that for every following one
+	callbackAttempts = 3
+	callbackBackoff  = 5 * time.Second
+	callbackTimeout  = 10 * time.Second
+
+	// signatureHeader contains the hex encoded HMAC-SHA256 of the
+	// callback body, using the app secret as the key
+	signatureHeader = "X-Webhooks-Signature"
+	jobIDHeader     = "X-Webhooks-Job"
+)
+
+var callbackClient = &http.Client{Timeout: callbackTimeout}
+
+// jobCallback is an url that is notified once a job is done
+type jobCallback struct {
+	URL        string
+	Delivered  *time.Time         `json:",omitempty"`
+	Deliveries []callbackDelivery `json:",omitempty"`
+}
+
+// callbackDelivery records a single attempt at sending a callback
+type callbackDelivery struct {
+	Time   time.Time
+	Status int    `json:",omitempty"`
+	Error  string `json:",omitempty"`
+}
+
+// parseCallbackURL checks that a callback url can be used.
+func parseCallbackURL(raw string) (string, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		e := fmt.Sprintf("invalid callback url: %s", raw)
+		return "", errors.New(e)
+	}
+
+	return u.String(), nil
+}
+
+// signPayload returns the signature of a callback body, in the
+// form that is sent in the signature header.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+// sendCallbacks notifies all callbacks of a job that is done, without
+// blocking the caller.
+func sendCallbacks(job *jobData) {
+	if len(job.Callbacks) == 0 {
+		return
+	}
+
+	// NOTE(happens): The job might still be read by others, so
+	// deliveries are recorded on a copy of it
+	delivery := *job
+	delivery.Callbacks = append([]jobCallback{}, job.Callbacks...)
+	go deliverCallbacks(&delivery)
+}
+
+func deliverCallbacks(job *jobData) {
+	for i := range job.Callbacks {
+		callback := &job.Callbacks[i]
+
+		// NOTE(happens): Only the hash of the secret is stored, so
+		// jobs that were resumed after a restart can't be signed
+		if len(job.secret) == 0 {
+			callback.Deliveries = append(callback.Deliveries, callbackDelivery{
+				Time:  time.Now(),
+				Error: "the app secret is not known after a server restart, callback was not sent",
+			})
+
+			continue
+		}
+
+		deliverCallback(job, callback)
+	}
+}
+
+// deliverCallback sends the summary of a job to a single callback,
+// retrying failed deliveries and recording every attempt.
+func deliverCallback(job *jobData, callback *jobCallback) {
+	body, err := json.Marshal(newJobResponse(job))
+	if err != nil {
+		fmt.Printf("unable to encode callback for job %d: %v\n", job.ID, err)
+		return
+	}
+
+	signature := signPayload(job.secret, body)
+	backoff := callbackBackoff
+
+	for attempt := 1; attempt <= callbackAttempts; attempt++ {
+		delivery := callbackDelivery{Time: time.Now()}
+		delivery.Status, err = postCallback(callback.URL, job.ID, body, signature)
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		callback.Deliveries = append(callback.Deliveries, delivery)
+		if err == nil {
+			callback.Delivered = &delivery.Time
+		}
+
+		// NOTE(happens): Only the callbacks are saved, since the
+		// stored job might have changed in the meantime
+		saveErr := updateJob(job.ID, func(stored *jobData) error {
+			stored.Callbacks = job.Callbacks
+			return nil
+		})
+
+		if saveErr != nil {
+			fmt.Printf("unable to save job %d: %v\n", job.ID, saveErr)
+		}
+
+		if err == nil {
+			return
+		}
+
+		fmt.Printf("callback for job %d failed: %v\n", job.ID, err)
+		if attempt < callbackAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func postCallback(target string, id uint64, body []byte, signature string) (int, error) {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, signature)
+	req.Header.Set(jobIDHeader, fmt.Sprintf("%d", id))
+
+	res, err := callbackClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		e := fmt.Sprintf("received status %d", res.StatusCode)
+		return res.StatusCode, errors.New(e)
+	}
+
+	return res.StatusCode, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSignPayload(t *testing.T) {
+	// NOTE(happens): The first case is from RFC 4231, receivers
+	// have to be able to verify the signature with any HMAC library
+	cases := []struct {
+		secret string
+		body   string
+		want   string
+	}{
+		{"Jefe", "what do ya want for nothing?", "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"", "", "sha256=b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+	}
+
+	for _, c := range cases {
+		if got := signPayload(c.secret, []byte(c.body)); got != c.want {
+			t.Errorf("signPayload(%q, %q) = %s, want %s", c.secret, c.body, got, c.want)
+		}
+	}
+
+	body := []byte(`{"id":1}`)
+	if signPayload("foo", body) == signPayload("bar", body) {
+		t.Errorf("signPayload should depend on the secret")
+	}
+
+	if signPayload("foo", body) == signPayload("foo", []byte(`{"id":2}`)) {
+		t.Errorf("signPayload should depend on the body")
+	}
+}
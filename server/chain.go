@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// splitRefs parses a comma separated list of hook references.
+func splitRefs(value string) []string {
+	result := []string{}
+	for _, ref := range strings.Split(value, ",") {
+		if ref = strings.TrimSpace(ref); len(ref) > 0 {
+			result = append(result, ref)
+		}
+	}
+
+	return result
+}
+
+// resolveRef returns the app and name of a hook reference,
+// relative to the app of the hook that contains it.
+func resolveRef(app, ref string) (string, string) {
+	if i := strings.Index(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+
+	return app, ref
+}
+
+// followUps returns all hooks that a hook can trigger.
+func (h hookData) followUps() []string {
+	return append(append([]string{}, h.OnSuccess...), h.OnFailure...)
+}
+
+func readHook(tx *bolt.Tx, app, name string) (*hookData, error) {
+	appBucket := tx.Bucket([]byte(fmt.Sprintf("app/%s", app)))
+	if appBucket == nil {
+		return nil, nil
+	}
+
+	raw := appBucket.Get([]byte(name))
+	if raw == nil {
+		return nil, nil
+	}
+
+	var found hookData
+	if err := json.Unmarshal(raw, &found); err != nil {
+		return nil, err
+	}
+
+	return &found, nil
+}
+
+// checkChain makes sure that all follow-ups of a hook exist and can be
+// triggered without parameters, and that following them never leads
+// back to the hook itself.
+func checkChain(tx *bolt.Tx, app string, hook hookData) error {
+	start := fmt.Sprintf("%s/%s", app, hook.Name)
+
+	for _, ref := range hook.followUps() {
+		refApp, refName := resolveRef(app, ref)
+		target, err := readHook(tx, refApp, refName)
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("%s/%s", refApp, refName)
+		if target == nil && key != start {
+			e := fmt.Sprintf("follow-up hook %s does not exist", key)
+			return errors.New(e)
+		}
+
+		if target == nil {
+			target = &hook
+		}
+
+		for _, arg := range target.Args {
+			if target.needsParam(arg) {
+				e := fmt.Sprintf("follow-up hook %s needs the argument %s, which can't be given by a chain", key, arg)
+				return errors.New(e)
+			}
+		}
+	}
+
+	// NOTE(happens): The hook being changed is not saved yet,
+	// so it is used instead of the stored version. Hooks can be
+	// reached on more than one path, but a hook that didn't lead
+	// back to the start once won't do so on another path either.
+	visited := map[string]bool{start: true}
+	var visit func(key string, path []string) error
+	visit = func(key string, path []string) error {
+		next := hook.followUps()
+		if key != start {
+			refApp, refName := resolveRef("", key)
+			found, err := readHook(tx, refApp, refName)
+			if err != nil || found == nil {
+				return err
+			}
+
+			next = found.followUps()
+		}
+
+		for _, ref := range next {
+			refApp, refName := resolveRef(strings.SplitN(key, "/", 2)[0], ref)
+			child := fmt.Sprintf("%s/%s", refApp, refName)
+			if child == start {
+				cycle := strings.Join(append(path, child), " -> ")
+				e := fmt.Sprintf("follow-up hooks would form a cycle: %s", cycle)
+				return errors.New(e)
+			}
+
+			if visited[child] {
+				continue
+			}
+
+			visited[child] = true
+			if err := visit(child, append(path, child)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return visit(start, []string{start})
+}
+
+// referrers returns all other hooks that have the given
+// hook as a follow-up, as app/hook.
+func referrers(tx *bolt.Tx, app, name string) ([]string, error) {
+	result := []string{}
+	key := fmt.Sprintf("%s/%s", app, name)
+
+	err := tx.ForEach(func(bucket []byte, b *bolt.Bucket) error {
+		if !strings.HasPrefix(string(bucket), "app/") {
+			return nil
+		}
+
+		refApp := strings.TrimPrefix(string(bucket), "app/")
+		return b.ForEach(func(k []byte, v []byte) error {
+			var hook hookData
+			if err := json.Unmarshal(v, &hook); err != nil {
+				return nil
+			}
+
+			from := fmt.Sprintf("%s/%s", refApp, hook.Name)
+			if from == key {
+				return nil
+			}
+
+			for _, ref := range hook.followUps() {
+				followApp, followName := resolveRef(refApp, ref)
+				if fmt.Sprintf("%s/%s", followApp, followName) == key {
+					result = append(result, from)
+					break
+				}
+			}
+
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// checkReferrers makes sure that a changed hook can still be
+// triggered by all hooks that have it as a follow-up.
+func checkReferrers(tx *bolt.Tx, app string, hook hookData) error {
+	refs, err := referrers(tx, app, hook.Name)
+	if err != nil {
+		return err
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	for _, arg := range hook.Args {
+		if hook.needsParam(arg) {
+			e := fmt.Sprintf(
+				"hook %s/%s is a follow-up of %s, so it can't need the argument %s",
+				app, hook.Name, strings.Join(refs, ", "), arg,
+			)
+			return errors.New(e)
+		}
+	}
+
+	return nil
+}
+
+// chainJob triggers the follow-up hooks of a job that is done.
+func chainJob(job *jobData) {
+	hook, err := loadHook(job.App, job.Hook)
+	if err != nil {
+		return
+	}
+
+	refs := []string{}
+	switch job.Status {
+	case jobSucceeded:
+		refs = hook.OnSuccess
+	case jobFailed, jobTimedOut:
+		refs = hook.OnFailure
+	}
+
+	if len(refs) == 0 {
+		return
+	}
+
+	followUps := []jobFollowUp{}
+	for _, ref := range refs {
+		app, name := resolveRef(job.App, ref)
+		followUp := jobFollowUp{Hook: fmt.Sprintf("%s/%s", app, name)}
+		followUp.Job, followUp.Error = triggerFollowUp(job, app, name)
+		followUps = append(followUps, followUp)
+
+		if len(followUp.Error) > 0 {
+			fmt.Printf("unable to trigger %s after job %d: %s\n", followUp.Hook, job.ID, followUp.Error)
+			continue
+		}
+
+		fmt.Printf("job %d triggered job %d for %s\n", job.ID, followUp.Job, followUp.Hook)
+	}
+
+	// NOTE(happens): Only the follow-ups are saved, since callbacks
+	// might be delivered at the same time
+	err = updateJob(job.ID, func(stored *jobData) error {
+		stored.FollowUps = followUps
+		return nil
+	})
+
+	if err != nil {
+		fmt.Printf("unable to save follow-ups of job %d: %v\n", job.ID, err)
+	}
+}
+
+// triggerFollowUp starts a follow-up hook of a job, and returns the id
+// of the new job or the reason why it couldn't be started.
+func triggerFollowUp(job *jobData, app, name string) (uint64, string) {
+	if !appEnabled(app) {
+		return 0, fmt.Sprintf("hooks are not enabled for %s", app)
+	}
+
+	target, err := loadHook(app, name)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	params := hookParams(app, nil)
+	trigger := jobTrigger{Source: sourceChain, Parent: job.ID}
+	next, _, err := startJob(app, target, params, trigger)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	return next.ID, ""
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestCheckChain(t *testing.T) {
+	cases := []struct {
+		name   string
+		stored map[string]hookData
+		hook   hookData
+		ok     bool
+	}{
+		{"no follow-ups", nil, hookData{Name: "a"}, true},
+		{"missing follow-up", nil, hookData{Name: "a", OnSuccess: []string{"b"}}, false},
+		{"self", nil, hookData{Name: "a", OnFailure: []string{"a"}}, false},
+		{"chain", map[string]hookData{
+			"foo/b": {Name: "b", OnSuccess: []string{"c"}},
+			"foo/c": {Name: "c"},
+		}, hookData{Name: "a", OnSuccess: []string{"b"}}, true},
+		{"cycle", map[string]hookData{
+			"foo/b": {Name: "b", OnSuccess: []string{"c"}},
+			"foo/c": {Name: "c", OnFailure: []string{"a"}},
+		}, hookData{Name: "a", OnSuccess: []string{"b"}}, false},
+		{"cycle through another app", map[string]hookData{
+			"bar/b": {Name: "b", OnSuccess: []string{"foo/a"}},
+		}, hookData{Name: "a", OnSuccess: []string{"bar/b"}}, false},
+		// NOTE(happens): Both b and c lead to d, which is only
+		// followed once
+		{"diamond", map[string]hookData{
+			"foo/b": {Name: "b", OnSuccess: []string{"d"}},
+			"foo/c": {Name: "c", OnSuccess: []string{"d"}},
+			"foo/d": {Name: "d"},
+		}, hookData{Name: "a", OnSuccess: []string{"b", "c"}}, true},
+		{"cycle after a diamond", map[string]hookData{
+			"foo/b": {Name: "b", OnSuccess: []string{"d"}},
+			"foo/c": {Name: "c", OnSuccess: []string{"d"}},
+			"foo/d": {Name: "d", OnFailure: []string{"a"}},
+		}, hookData{Name: "a", OnSuccess: []string{"b", "c"}}, false},
+		// NOTE(happens): A hook that is already on the path must not
+		// stop its siblings from being followed
+		{"cycle behind a visited sibling", map[string]hookData{
+			"foo/b": {Name: "b", OnSuccess: []string{"c"}},
+			"foo/c": {Name: "c", OnSuccess: []string{"b", "a"}},
+		}, hookData{Name: "a", OnSuccess: []string{"b"}}, false},
+	}
+
+	for _, c := range cases {
+		resetTestStorage(t)
+
+		for key, hook := range c.stored {
+			app, _ := resolveRef("", key)
+			putTestHook(t, app, hook)
+		}
+
+		err := hookStorage.View(func(tx *bolt.Tx) error {
+			return checkChain(tx, "foo", c.hook)
+		})
+
+		if c.ok && err != nil {
+			t.Errorf("%s: checkChain failed: %v", c.name, err)
+		}
+
+		if !c.ok && err == nil {
+			t.Errorf("%s: checkChain should fail", c.name)
+		}
+	}
+}
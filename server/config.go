@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Server-wide settings, read from the environment on startup.
+var (
+	// defaultTimeout applies to hooks that don't set their own
+	// timeout. Zero means jobs can run forever.
+	defaultTimeout time.Duration
+
+	// maxJobsPerHook, maxJobAge and maxOutputSize limit the job history
+	// that is kept. Zero disables the respective limit.
+	maxJobsPerHook int
+	maxJobAge      time.Duration
+	maxOutputSize  int
+	// pruneInterval is how often the job history is pruned
+	pruneInterval time.Duration
+
+	// poolSize is the number of jobs that can run at the same time
+	// across all apps, and queueSize the number of jobs that can wait
+	// for a worker. Zero means unlimited.
+	poolSize  int
+	queueSize int
+
+	// smtpAddr is the host:port of the mail server used by email
+	// notifiers, which are disabled if it is empty
+	smtpAddr     string
+	smtpUser     string
+	smtpPassword string
+	smtpFrom     string
+)
+
+func loadConfig() {
+	defaultTimeout = envSeconds("WEBHOOKS_TIMEOUT", 0)
+
+	maxJobsPerHook = envInt("WEBHOOKS_MAX_JOBS_PER_HOOK", 100)
+	maxJobAge = envSeconds("WEBHOOKS_MAX_JOB_AGE", 30*24*time.Hour)
+	maxOutputSize = envInt("WEBHOOKS_MAX_OUTPUT_SIZE", 64*1024)
+	pruneInterval = envSeconds("WEBHOOKS_PRUNE_INTERVAL", time.Hour)
+
+	poolSize = envInt("WEBHOOKS_WORKERS", 4)
+	queueSize = envInt("WEBHOOKS_QUEUE_SIZE", 100)
+
+	smtpAddr = os.Getenv("WEBHOOKS_SMTP_ADDR")
+	smtpUser = os.Getenv("WEBHOOKS_SMTP_USER")
+	smtpPassword = os.Getenv("WEBHOOKS_SMTP_PASSWORD")
+	smtpFrom = os.Getenv("WEBHOOKS_SMTP_FROM")
+	if len(smtpFrom) == 0 {
+		smtpFrom = "webhooks@localhost"
+	}
+}
+
+// envInt reads a non-negative number from an env var, falling back
+// to the default if it is unset or invalid.
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return fallback
+	}
+
+	result, err := strconv.Atoi(value)
+	if err != nil || result < 0 {
+		fmt.Printf("invalid value for %s, using default: %s\n", name, value)
+		return fallback
+	}
+
+	return result
+}
+
+// envSeconds reads a duration in seconds from an env var, falling back
+// to the default if it is unset or invalid.
+func envSeconds(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return fallback
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		fmt.Printf("invalid value for %s, using default: %s\n", name, value)
+		return fallback
+	}
+
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression with the usual five fields:
+// minute, hour, day of month, month and day of week. Every field is
+// stored as a bit set of the values it matches.
+type cronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	// NOTE(happens): Like in cron, a job runs if either the day of
+	// month or the day of week matches, unless one of them is *
+	domStar bool
+	dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression, or one of the
+// macros like @daily.
+func parseCron(spec string) (cronSchedule, error) {
+	var result cronSchedule
+
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		e := fmt.Sprintf("invalid schedule %q: expected 5 fields, got %d", spec, len(fields))
+		return result, errors.New(e)
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := cronFields[i].parse(field)
+		if err != nil {
+			e := fmt.Sprintf("invalid schedule %q: %v", spec, err)
+			return result, errors.New(e)
+		}
+
+		sets[i] = set
+	}
+
+	result.minute, result.hour, result.dom = sets[0], sets[1], sets[2]
+	result.month, result.dow = sets[3], sets[4]
+	// NOTE(happens): Like in vixie cron, fields such as */2 also count
+	// as *, even though they don't match every day
+	result.domStar = strings.HasPrefix(fields[2], "*")
+	result.dowStar = strings.HasPrefix(fields[4], "*")
+
+	// NOTE(happens): 7 is another way to write sunday
+	if result.dow&(1<<7) != 0 {
+		result.dow |= 1
+	}
+
+	return result, nil
+}
+
+// parse returns the bit set of all values matched by a single field,
+// which is a comma separated list of values, ranges and steps.
+func (f cronField) parse(field string) (uint64, error) {
+	var result uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				e := fmt.Sprintf("invalid step in %s: %s", f.name, part)
+				return 0, errors.New(e)
+			}
+
+			part = part[:i]
+		}
+
+		from, to := f.min, f.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+
+			if to, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+
+			if from > to {
+				e := fmt.Sprintf("invalid range in %s: %s", f.name, part)
+				return 0, errors.New(e)
+			}
+		default:
+			var err error
+			if from, err = f.value(part); err != nil {
+				return 0, err
+			}
+
+			// NOTE(happens): A single value with a step
+			// means every step starting at that value
+			to = from
+			if step > 1 {
+				to = f.max
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			result |= 1 << uint(v)
+		}
+	}
+
+	return result, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		e := fmt.Sprintf("invalid %s: %s, must be between %d and %d", f.name, s, f.min, f.max)
+		return 0, errors.New(e)
+	}
+
+	return v, nil
+}
+
+func (s cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
+
+// Next returns the first time after t that matches the schedule, or
+// the zero time if there is none within the next five years.
+func (s cronSchedule) Next(t time.Time) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, t.Location())
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	cases := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"@sometimes",
+	}
+
+	for _, spec := range cases {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) should fail", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// NOTE(happens): 2019-05-01 is a wednesday
+	from := time.Date(2019, 5, 1, 10, 30, 15, 0, time.UTC)
+
+	cases := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2019, 5, 1, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2019, 5, 1, 10, 45, 0, 0, time.UTC)},
+		{"5/20 * * * *", from, time.Date(2019, 5, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 9-17 * * *", from, time.Date(2019, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"0,30 * * * *", from, time.Date(2019, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"@daily", from, time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", from, time.Date(2019, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", from, time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", from, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * feb *", from, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", from, time.Date(2019, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * mon-fri", time.Date(2019, 5, 3, 13, 0, 0, 0, time.UTC), time.Date(2019, 5, 6, 12, 0, 0, 0, time.UTC)},
+
+		// NOTE(happens): 0 and 7 are both sunday
+		{"0 0 * * 0", from, time.Date(2019, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2019, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", from, time.Date(2019, 5, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 6-7", from, time.Date(2019, 5, 4, 0, 0, 0, 0, time.UTC)},
+
+		// NOTE(happens): If both day fields are restricted, either
+		// of them has to match
+		{"0 0 15 * fri", from, time.Date(2019, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 2 * fri", from, time.Date(2019, 5, 2, 0, 0, 0, 0, time.UTC)},
+		// NOTE(happens): If one of them is *, only the other one counts
+		{"0 0 15 * *", from, time.Date(2019, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * fri", from, time.Date(2019, 5, 3, 0, 0, 0, 0, time.UTC)},
+		// NOTE(happens): Fields starting with * count as *, so both
+		// day fields have to match for these
+		{"0 0 */2 * fri", from, time.Date(2019, 5, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */10 * fri", from, time.Date(2019, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * */7", from, time.Date(2019, 10, 13, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * */7", from, time.Date(2019, 9, 1, 0, 0, 0, 0, time.UTC)},
+
+		{"0 0 30 2 *", from, time.Time{}},
+	}
+
+	for _, c := range cases {
+		s, err := parseCron(c.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed: %v", c.spec, err)
+			continue
+		}
+
+		if got := s.Next(c.from); !got.Equal(c.want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", c.spec, c.from, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// jobEvent is published whenever a running job changes its
+// status or produces output.
+type jobEvent struct {
+	Status jobStatus `json:"status,omitempty"`
+	Output string    `json:"output,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// watcherBuffer is the number of events that are buffered for each
+// watcher. If a watcher falls behind further than this, events
+// are dropped for it instead of blocking the job.
+const watcherBuffer = 64
+
+var watchersMu sync.Mutex
+var watchers = make(map[uint64][]chan jobEvent)
+
+// watchJob subscribes to the events of a job. The returned channel is
+// closed once the job is done. Callers have to check whether the job is
+// already done after subscribing, since a finished job won't publish any
+// more events. The returned func has to be called to unsubscribe.
+func watchJob(id uint64) (<-chan jobEvent, func()) {
+	ch := make(chan jobEvent, watcherBuffer)
+
+	watchersMu.Lock()
+	watchers[id] = append(watchers[id], ch)
+	watchersMu.Unlock()
+
+	unwatch := func() {
+		watchersMu.Lock()
+		defer watchersMu.Unlock()
+
+		list := watchers[id]
+		for i, w := range list {
+			if w == ch {
+				watchers[id] = append(list[:i], list[i+1:]...)
+				close(ch)
+				break
+			}
+		}
+
+		if len(watchers[id]) == 0 {
+			delete(watchers, id)
+		}
+	}
+
+	return ch, unwatch
+}
+
+// jobBacklog returns the events needed to catch a new watcher up
+// with the current state of a job.
+func jobBacklog(job *jobData) []jobEvent {
+	result := []jobEvent{{Status: job.Status}}
+
+	if job.Done() {
+		if len(job.Output) > 0 {
+			result = append(result, jobEvent{Output: job.Output})
+		}
+
+		return result
+	}
+
+	for _, attempt := range job.Attempts {
+		if len(attempt.Output) > 0 {
+			result = append(result, jobEvent{Output: attempt.Output})
+		}
+	}
+
+	// NOTE(happens): Steps that were run before the last attempt
+	// finished are already part of its output
+	var since time.Time
+	if len(job.Attempts) > 0 {
+		since = job.Attempts[len(job.Attempts)-1].Finished
+	}
+
+	for i, step := range job.Steps {
+		if step.Finished == nil || !step.Finished.After(since) {
+			continue
+		}
+
+		if output := job.StepOutput(i); len(output) > 0 {
+			result = append(result, jobEvent{Output: output})
+		}
+	}
+
+	return result
+}
+
+func publishJob(id uint64, ev jobEvent) {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+
+	for _, ch := range watchers[id] {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// closeWatchers closes the channels of all watchers of a job. This
+// has to be called after the final state of the job has been saved.
+func closeWatchers(id uint64) {
+	watchersMu.Lock()
+	defer watchersMu.Unlock()
+
+	for _, ch := range watchers[id] {
+		close(ch)
+	}
+
+	delete(watchers, id)
+}
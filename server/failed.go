@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// listFailedJobs returns all jobs that failed and were not acknowledged
+// yet, newest first. If app is empty, jobs of all apps are returned.
+func listFailedJobs(app string) ([]jobData, error) {
+	result := []jobData{}
+
+	err := jobStorage.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(jobsBucket)).Cursor()
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var job jobData
+			if err := json.Unmarshal(v, &job); err != nil {
+				continue
+			}
+
+			if len(app) > 0 && job.App != app {
+				continue
+			}
+
+			if job.Failed() && job.Acked == nil {
+				result = append(result, job)
+			}
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// ackJob dismisses a failed job, so that it is no longer listed.
+func ackJob(id uint64) error {
+	return updateJob(id, func(job *jobData) error {
+		if !job.Failed() {
+			e := fmt.Sprintf("job %d did not fail, it is %s", id, job.Status)
+			return errors.New(e)
+		}
+
+		if job.Acked != nil {
+			e := fmt.Sprintf("job %d was already acknowledged", id)
+			return errors.New(e)
+		}
+
+		now := time.Now()
+		job.Acked = &now
+		return nil
+	})
+}
+
+// checkRetry returns an error if a job can't be retried.
+func checkRetry(job *jobData) error {
+	if !job.Failed() {
+		e := fmt.Sprintf("job %d did not fail, it is %s", job.ID, job.Status)
+		return errors.New(e)
+	}
+
+	if job.RetriedAs > 0 {
+		e := fmt.Sprintf("job %d was already retried as job %d", job.ID, job.RetriedAs)
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+// retryJob runs the commands of a failed job again, exactly as they
+// were rendered for it. The retry is submitted like any other trigger
+// of the hook, so it respects its concurrency settings and is rejected
+// while the hook is cooling down. It is never debounced or merged with
+// a deferred job. Every failed job can only be retried once, and is
+// acknowledged by the retry.
+func retryJob(id uint64) (*jobData, triggerOutcome, error) {
+	failed, err := getJob(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := checkRetry(failed); err != nil {
+		return nil, "", err
+	}
+
+	hook, err := loadHook(failed.App, failed.Hook)
+	if err != nil {
+		e := fmt.Sprintf("unable to retry job %d: %v", id, err)
+		return nil, "", errors.New(e)
+	}
+
+	steps := []jobStep{}
+	for _, step := range failed.Steps {
+		steps = append(steps, jobStep{Command: step.Command, Argv: step.Argv, Always: step.Always})
+	}
+
+	if len(steps) == 0 && len(failed.Command) > 0 {
+		steps = append(steps, jobStep{Command: failed.Command})
+	}
+
+	job := &jobData{
+		App:     failed.App,
+		Hook:    failed.Hook,
+		Steps:   steps,
+		Source:  sourceRetry,
+		RetryOf: failed.ID,
+		Status:  jobQueued,
+		Created: time.Now(),
+		Timeout: failed.Timeout,
+		Retry:   failed.Retry,
+		done:    make(chan struct{}),
+	}
+
+	if err := assignJobID(job); err != nil {
+		return nil, "", err
+	}
+
+	// NOTE(happens): The failed job is claimed before the retry is
+	// submitted, so that two retries of the same job can't both run
+	var acked *time.Time
+	err = updateJob(failed.ID, func(stored *jobData) error {
+		if err := checkRetry(stored); err != nil {
+			return err
+		}
+
+		acked = stored.Acked
+		stored.RetriedAs = job.ID
+		if stored.Acked == nil {
+			now := time.Now()
+			stored.Acked = &now
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	accepted, outcome, err := submitJob(job, hook)
+	if err == nil {
+		return accepted, outcome, nil
+	}
+
+	undoErr := updateJob(failed.ID, func(stored *jobData) error {
+		if stored.RetriedAs == job.ID {
+			stored.RetriedAs = 0
+			stored.Acked = acked
+		}
+
+		return nil
+	})
+
+	if undoErr != nil {
+		fmt.Printf("unable to release job %d after its retry failed: %v\n", failed.ID, undoErr)
+	}
+
+	return nil, "", err
+}
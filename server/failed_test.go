@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckRetry(t *testing.T) {
+	cases := []struct {
+		job jobData
+		ok  bool
+	}{
+		{jobData{ID: 1, Status: jobFailed}, true},
+		{jobData{ID: 1, Status: jobTimedOut}, true},
+		{jobData{ID: 1, Status: jobSucceeded}, false},
+		{jobData{ID: 1, Status: jobRunning}, false},
+		{jobData{ID: 1, Status: jobFailed, RetriedAs: 2}, false},
+	}
+
+	for _, c := range cases {
+		err := checkRetry(&c.job)
+		if c.ok && err != nil {
+			t.Errorf("checkRetry(%s, retried as %d) failed: %v", c.job.Status, c.job.RetriedAs, err)
+		}
+
+		if !c.ok && err == nil {
+			t.Errorf("checkRetry(%s, retried as %d) should fail", c.job.Status, c.job.RetriedAs)
+		}
+	}
+}
+
+func TestAcceptRetry(t *testing.T) {
+	lastSuccess := time.Now().Unix()
+
+	cases := []struct {
+		name    string
+		hook    hookData
+		retry   bool
+		delayed bool
+		want    triggerOutcome
+	}{
+		{"retry", hookData{}, true, false, outcomeExecuted},
+		{"trigger with debounce", hookData{Debounce: 10}, false, false, outcomeDeferred},
+		{"retry with debounce", hookData{Debounce: 10}, true, false, outcomeExecuted},
+		{"trigger with deferred job", hookData{}, false, true, outcomeCoalesced},
+		{"retry with deferred job", hookData{}, true, true, ""},
+		{"trigger in cooldown", hookData{Cooldown: 60, CooldownMode: cooldownDefer, LastSuccess: &lastSuccess}, false, false, outcomeDeferred},
+		{"retry in cooldown", hookData{Cooldown: 60, CooldownMode: cooldownDefer, LastSuccess: &lastSuccess}, true, false, ""},
+		{"retry in rejecting cooldown", hookData{Cooldown: 60, LastSuccess: &lastSuccess}, true, false, ""},
+	}
+
+	for _, c := range cases {
+		q := &appQueue{
+			active:  make(map[uint64]activeJob),
+			delayed: make(map[string]*jobData),
+		}
+
+		deferred := &jobData{ID: 1, Hook: "foo", Steps: []jobStep{{Command: "ps:rebuild"}}}
+		if c.delayed {
+			q.delayed["foo"] = deferred
+		}
+
+		job := &jobData{Hook: "foo", Steps: []jobStep{{Command: "ps:restart"}}}
+		if c.retry {
+			job.RetryOf = 1
+		}
+
+		_, outcome, _, err := q.accept(job, c.hook)
+		if len(c.want) == 0 {
+			if err == nil {
+				t.Errorf("%s: accept should fail, got %s", c.name, outcome)
+			}
+
+			if deferred.Steps[0].Command != "ps:rebuild" {
+				t.Errorf("%s: deferred job was changed", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: accept failed: %v", c.name, err)
+			continue
+		}
+
+		if outcome != c.want {
+			t.Errorf("%s: outcome = %s, want %s", c.name, outcome, c.want)
+		}
+	}
+}
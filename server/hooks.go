@@ -0,0 +1,620 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+type hookData struct {
+	Name string
+	// CommandTemplate is only set for hooks that were created
+	// before hooks could have several steps
+	CommandTemplate string `json:",omitempty"`
+	// Steps are run one after another for every job, until
+	// the first one fails
+	Steps          []hookStep `json:",omitempty"`
+	Args           []string
+	LastActivation *int64
+	LastSuccess    *int64 `json:",omitempty"`
+
+	// Wait makes the endpoint respond only after the command has
+	// finished, for at most MaxWait seconds.
+	Wait    bool
+	MaxWait int `json:",omitempty"`
+
+	// Concurrency decides what happens when the hook is triggered
+	// while the app already has jobs queued or running.
+	Concurrency concurrencyPolicy `json:",omitempty"`
+
+	// Debounce coalesces all triggers within this many seconds after
+	// the first one into a single job. Triggers within Cooldown
+	// seconds of the last successful job are handled according
+	// to CooldownMode.
+	Debounce     int          `json:",omitempty"`
+	Cooldown     int          `json:",omitempty"`
+	CooldownMode cooldownMode `json:",omitempty"`
+
+	// Timeout is the number of seconds after which a running job is
+	// aborted. If it is zero, the server default is used.
+	Timeout int `json:",omitempty"`
+
+	// Retry decides whether failed jobs are run again
+	Retry retryPolicy
+
+	// OnRestart decides what happens to unfinished jobs when
+	// the server is restarted.
+	OnRestart restartPolicy `json:",omitempty"`
+
+	// Schedules are cron expressions that the hook is run on by
+	// the server. Runs that were missed are handled according
+	// to CatchUp.
+	Schedules []hookSchedule `json:",omitempty"`
+	CatchUp   catchUpPolicy  `json:",omitempty"`
+
+	// OnSuccess and OnFailure are hooks that are triggered once a job
+	// of this hook has succeeded or failed. They are referenced as
+	// app/hook, or just by name for hooks of the same app.
+	OnSuccess []string `json:",omitempty"`
+	OnFailure []string `json:",omitempty"`
+
+	// Params declares the values that parameters accept
+	Params []paramDecl `json:",omitempty"`
+
+	// Mappings take parameters from the body or headers of
+	// http requests instead of the query params.
+	Mappings []paramMapping `json:",omitempty"`
+}
+
+// hookStep is a single command template of a hook
+type hookStep struct {
+	// Argv contains the arguments of the command. Parameters are
+	// only ever substituted within a single argument.
+	Argv []string `json:",omitempty"`
+	// Template is only set for steps that were created before
+	// commands were stored as arguments
+	Template string `json:",omitempty"`
+	// Always makes the step run even if an earlier step failed,
+	// which is useful for cleaning up
+	Always bool `json:",omitempty"`
+}
+
+// SetOption sets a single hook option by the name of its cli flag.
+func (h *hookData) SetOption(key, value string) error {
+	var err error
+
+	switch key {
+	case "wait":
+		h.Wait, err = strconv.ParseBool(value)
+	case "max-wait":
+		h.MaxWait, err = parseSeconds(value)
+	case "concurrency":
+		policy := concurrencyPolicy(value)
+		if !policy.Valid() {
+			err = errors.New("must be one of allow, queue, skip, replace")
+			break
+		}
+
+		h.Concurrency = policy
+	case "debounce":
+		h.Debounce, err = parseSeconds(value)
+	case "cooldown":
+		h.Cooldown, err = parseSeconds(value)
+	case "timeout":
+		h.Timeout, err = parseSeconds(value)
+	case "max-attempts":
+		h.Retry.MaxAttempts, err = strconv.Atoi(value)
+		if err == nil && h.Retry.MaxAttempts < 1 {
+			err = errors.New("must be at least 1")
+		}
+	case "backoff":
+		// NOTE(happens): Zero is stored as the default, so it
+		// can't be used to retry right away
+		h.Retry.Backoff, err = strconv.Atoi(value)
+		if err == nil && h.Retry.Backoff < 1 {
+			err = errors.New("must be at least 1")
+		}
+	case "backoff-multiplier":
+		h.Retry.Multiplier, err = strconv.ParseFloat(value, 64)
+		if err == nil && h.Retry.Multiplier < 1 {
+			err = errors.New("must be at least 1")
+		}
+	case "retry-on":
+		h.Retry.On, err = parseFailureClasses(value)
+	case "on-restart":
+		policy := restartPolicy(value)
+		if policy != restartResume && policy != restartInterrupt {
+			err = errors.New("must be one of resume, interrupt")
+			break
+		}
+
+		h.OnRestart = policy
+	case "catch-up":
+		policy := catchUpPolicy(value)
+		if policy != catchUpSkip && policy != catchUpOnce && policy != catchUpAll {
+			err = errors.New("must be one of skip, once, all")
+			break
+		}
+
+		h.CatchUp = policy
+	case "on-success":
+		h.OnSuccess = splitRefs(value)
+	case "on-failure":
+		h.OnFailure = splitRefs(value)
+	case "always":
+		err = h.setAlways(value)
+	case "param":
+		return h.setParam(value)
+	case "remove-param":
+		return h.removeParam(value)
+	case "map":
+		return h.setMapping(value)
+	case "remove-map":
+		return h.removeMapping(value)
+	case "cooldown-mode":
+		mode := cooldownMode(value)
+		if mode != cooldownReject && mode != cooldownDefer {
+			err = errors.New("must be one of reject, defer")
+			break
+		}
+
+		h.CooldownMode = mode
+	default:
+		e := fmt.Sprintf("unknown hook option: %s", key)
+		return errors.New(e)
+	}
+
+	if err != nil {
+		e := fmt.Sprintf("invalid value for %s: %v", key, err)
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+// setAlways marks the given comma separated step numbers as always
+// run, and all other steps as not.
+func (h *hookData) setAlways(value string) error {
+	steps := h.steps()
+	always := make(map[int]bool)
+
+	for _, n := range strings.Split(value, ",") {
+		if len(n) == 0 {
+			continue
+		}
+
+		i, err := strconv.Atoi(n)
+		if err != nil || i < 1 || i > len(steps) {
+			e := fmt.Sprintf("no step number %s, must be between 1 and %d", n, len(steps))
+			return errors.New(e)
+		}
+
+		always[i-1] = true
+	}
+
+	for i := range steps {
+		steps[i].Always = always[i]
+	}
+
+	h.SetSteps(steps)
+	return nil
+}
+
+func parseSeconds(value string) (int, error) {
+	result, err := strconv.Atoi(value)
+	if err == nil && result < 0 {
+		err = errors.New("must not be negative")
+	}
+
+	return result, err
+}
+
+// SetOptions sets hook options from a list of key=value pairs.
+func (h *hookData) SetOptions(opts []string) error {
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			e := fmt.Sprintf("invalid hook option: %s", opt)
+			return errors.New(e)
+		}
+
+		if err := h.SetOption(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Options returns a short description of all options that
+// differ from their defaults.
+func (h hookData) Options() string {
+	opts := []string{}
+	if h.Wait {
+		opts = append(opts, "wait")
+	}
+
+	if h.MaxWait > 0 {
+		opts = append(opts, fmt.Sprintf("max-wait=%ds", h.MaxWait))
+	}
+
+	if len(h.Concurrency) > 0 && h.Concurrency != concurrencyQueue {
+		opts = append(opts, fmt.Sprintf("concurrency=%s", h.Concurrency))
+	}
+
+	if h.Debounce > 0 {
+		opts = append(opts, fmt.Sprintf("debounce=%ds", h.Debounce))
+	}
+
+	if h.Cooldown > 0 {
+		opts = append(opts, fmt.Sprintf("cooldown=%ds (%s)", h.Cooldown, h.cooldownMode()))
+	}
+
+	if h.Timeout > 0 {
+		opts = append(opts, fmt.Sprintf("timeout=%ds", h.Timeout))
+	}
+
+	if h.Retry.Enabled() {
+		opts = append(opts, h.Retry.String())
+	}
+
+	if h.OnRestart == restartResume {
+		opts = append(opts, "on-restart=resume")
+	}
+
+	if len(h.Schedules) > 0 {
+		specs := []string{}
+		for _, s := range h.Schedules {
+			specs = append(specs, s.Spec)
+		}
+
+		opts = append(opts, fmt.Sprintf("schedule=%s (catch-up=%s)", strings.Join(specs, "; "), h.catchUp()))
+	}
+
+	if len(h.OnSuccess) > 0 {
+		opts = append(opts, fmt.Sprintf("on-success=%s", strings.Join(h.OnSuccess, ",")))
+	}
+
+	if len(h.OnFailure) > 0 {
+		opts = append(opts, fmt.Sprintf("on-failure=%s", strings.Join(h.OnFailure, ",")))
+	}
+
+	if len(opts) == 0 {
+		return "-"
+	}
+
+	return strings.Join(opts, ", ")
+}
+
+// argv returns the arguments of the step.
+func (s hookStep) argv() []string {
+	if len(s.Argv) == 0 {
+		return strings.Fields(s.Template)
+	}
+
+	return s.Argv
+}
+
+// String returns the command of the step.
+func (s hookStep) String() string {
+	return joinArgv(s.argv())
+}
+
+// SetSteps replaces all command templates of the hook.
+func (h *hookData) SetSteps(steps []hookStep) {
+	h.CommandTemplate = ""
+	h.Steps = []hookStep{}
+	h.Args = []string{}
+
+	seen := make(map[string]bool)
+	for _, step := range steps {
+		step = hookStep{Argv: step.argv(), Always: step.Always}
+		h.Steps = append(h.Steps, step)
+
+		for _, word := range step.Argv {
+			// NOTE(happens): Commands are checked before they are
+			// set, so this only skips broken legacy templates
+			tmpl, err := parseTemplate(word)
+			if err != nil {
+				continue
+			}
+
+			for _, arg := range tmpl.Params() {
+				if !seen[arg] {
+					seen[arg] = true
+					h.Args = append(h.Args, arg)
+				}
+			}
+		}
+	}
+}
+
+// SetCommands replaces all command templates of the hook with the
+// ones in cmds, which are separated by webhooks.StepSeparator.
+func (h *hookData) SetCommands(cmds string) error {
+	steps := []hookStep{}
+	for i, cmd := range strings.Split(cmds, webhooks.StepSeparator) {
+		argv, err := splitArgv(cmd)
+		if err != nil {
+			e := fmt.Sprintf("invalid command %d: %v", i+1, err)
+			return errors.New(e)
+		}
+
+		for _, word := range argv {
+			if err := checkArg(word); err != nil {
+				e := fmt.Sprintf("invalid command %d: %v", i+1, err)
+				return errors.New(e)
+			}
+
+			if _, err := parseTemplate(word); err != nil {
+				e := fmt.Sprintf("invalid command %d: %v", i+1, err)
+				return errors.New(e)
+			}
+		}
+
+		if len(argv) > 0 {
+			steps = append(steps, hookStep{Argv: argv})
+		}
+	}
+
+	h.SetSteps(steps)
+	return nil
+}
+
+func (h hookData) steps() []hookStep {
+	if len(h.Steps) == 0 && len(h.CommandTemplate) > 0 {
+		return []hookStep{{Template: h.CommandTemplate}}
+	}
+
+	result := make([]hookStep, len(h.Steps))
+	copy(result, h.Steps)
+	return result
+}
+
+// Commands returns a short description of all steps of the hook.
+func (h hookData) Commands() string {
+	steps := h.steps()
+	if len(steps) == 1 {
+		return steps[0].String()
+	}
+
+	result := []string{}
+	for i, step := range steps {
+		desc := fmt.Sprintf("%d. %s", i+1, step)
+		if step.Always {
+			desc = fmt.Sprintf("%s (always)", desc)
+		}
+
+		result = append(result, desc)
+	}
+
+	return strings.Join(result, "; ")
+}
+
+func (h hookData) concurrency() concurrencyPolicy {
+	if len(h.Concurrency) == 0 {
+		return concurrencyQueue
+	}
+
+	return h.Concurrency
+}
+
+func (h hookData) cooldownMode() cooldownMode {
+	if len(h.CooldownMode) == 0 {
+		return cooldownReject
+	}
+
+	return h.CooldownMode
+}
+
+func (h hookData) catchUp() catchUpPolicy {
+	if len(h.CatchUp) == 0 {
+		return catchUpSkip
+	}
+
+	return h.CatchUp
+}
+
+func (h hookData) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return time.Duration(h.Timeout) * time.Second
+	}
+
+	return defaultTimeout
+}
+
+// hookParams turns the parameters of a trigger into the arguments of a
+// command template. The app is always set, and can't be overridden.
+func hookParams(app string, values map[string]string) map[string]string {
+	params := make(map[string]string)
+	for k, v := range values {
+		key := fmt.Sprintf("#%s", k)
+		params[key] = v
+	}
+
+	params["#app"] = app
+	return params
+}
+
+// parseParams reads trigger parameters from a list of
+// key=value pairs.
+func parseParams(app string, pairs []string) (map[string]string, error) {
+	values := make(map[string]string)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			e := fmt.Sprintf("invalid parameter: %s, expected key=value", pair)
+			return nil, errors.New(e)
+		}
+
+		values[kv[0]] = kv[1]
+	}
+
+	return hookParams(app, values), nil
+}
+
+// GetSteps renders the command of every step of the hook. Every
+// parameter is substituted into exactly one argument, and values
+// are never substituted again.
+func (h hookData) GetSteps(args map[string]string) ([]jobStep, error) {
+	values := make(map[string]string)
+	problems := paramError{}
+
+	for _, arg := range h.Args {
+		decl := h.param(arg)
+		value, ok := args[arg]
+		if !ok && decl.Default != nil {
+			value, ok = *decl.Default, true
+		}
+
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s is missing", arg))
+			continue
+		}
+
+		if err := checkParamValue(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s %v", arg, err))
+		}
+
+		problems = append(problems, decl.Check(value)...)
+		values[arg] = value
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+
+	result := []jobStep{}
+	for _, step := range h.steps() {
+		argv := []string{}
+		for _, word := range step.argv() {
+			tmpl, err := parseTemplate(word)
+			if err != nil {
+				return nil, err
+			}
+
+			// NOTE(happens): Arguments that are only made of empty
+			// parameters can't be sent, so they are left out
+			if rendered := tmpl.Render(values); len(rendered) > 0 {
+				argv = append(argv, rendered)
+			}
+		}
+
+		result = append(result, jobStep{
+			Command: joinArgv(argv),
+			Argv:    argv,
+			Always:  step.Always,
+		})
+	}
+
+	return result, nil
+}
+
+// appEnabled checks whether hooks are enabled for an app.
+func appEnabled(app string) bool {
+	enabled := false
+	_ = hookStorage.View(func(tx *bolt.Tx) error {
+		apps := tx.Bucket([]byte(enabledBucket))
+		raw := apps.Get([]byte(app))
+		enabled = raw != nil && string(raw) == ""
+
+		return nil
+	})
+
+	return enabled
+}
+
+func loadHook(app, name string) (hookData, error) {
+	var found hookData
+	err := hookStorage.View(func(tx *bolt.Tx) error {
+		appBucketStr := fmt.Sprintf("app/%s", app)
+		appBucket := tx.Bucket([]byte(appBucketStr))
+		if appBucket == nil {
+			e := fmt.Sprintf("app %s does not have any hooks", app)
+			return errors.New(e)
+		}
+
+		foundRaw := appBucket.Get([]byte(name))
+		if foundRaw == nil {
+			e := fmt.Sprintf("app %s has no hook named %s", app, name)
+			// TODO(happens): Print available hooks for app?
+			return errors.New(e)
+		}
+
+		err := json.Unmarshal(foundRaw, &found)
+		if err != nil {
+			e := fmt.Sprintf("error reading hook data: %v, data:%v", err, foundRaw)
+			return errors.New(e)
+		}
+
+		return nil
+	})
+
+	return found, err
+}
+
+// updateHook loads a hook, applies fn to it and saves it again,
+// all in a single transaction.
+func updateHook(app, name string, fn func(h *hookData) error) error {
+	return editHook(app, name, func(_ *bolt.Tx, h *hookData) error {
+		return fn(h)
+	})
+}
+
+// editHook works like updateHook, but passes the transaction to fn
+// so that the changed hook can be checked against other hooks.
+func editHook(app, name string, fn func(tx *bolt.Tx, h *hookData) error) error {
+	return hookStorage.Update(func(tx *bolt.Tx) error {
+		appBucketStr := fmt.Sprintf("app/%s", app)
+		appBucket := tx.Bucket([]byte(appBucketStr))
+		if appBucket == nil {
+			e := fmt.Sprintf("app %s does not have any hooks", app)
+			return errors.New(e)
+		}
+
+		foundRaw := appBucket.Get([]byte(name))
+		if foundRaw == nil {
+			e := fmt.Sprintf("app %s has no hook named %s", app, name)
+			return errors.New(e)
+		}
+
+		var found hookData
+		if err := json.Unmarshal(foundRaw, &found); err != nil {
+			e := fmt.Sprintf("error reading hook data: %v", err)
+			return errors.New(e)
+		}
+
+		if err := fn(tx, &found); err != nil {
+			return err
+		}
+
+		ser, err := json.Marshal(found)
+		if err != nil {
+			e := fmt.Sprintf("failed to serialize hook: %v", err)
+			return errors.New(e)
+		}
+
+		err = appBucket.Put([]byte(name), ser)
+		if err != nil {
+			e := fmt.Sprintf("unable to save hook: %v", err)
+			return errors.New(e)
+		}
+
+		return nil
+	})
+}
+
+// touchHook sets the last activation time of a hook.
+func touchHook(app, name string, t time.Time) error {
+	activation := t.Unix()
+	return updateHook(app, name, func(h *hookData) error {
+		h.LastActivation = &activation
+		return nil
+	})
+}
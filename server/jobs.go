@@ -0,0 +1,739 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+type jobStatus string
+
+const (
+	jobQueued    jobStatus = "queued"
+	jobRunning   jobStatus = "running"
+	jobSucceeded jobStatus = "succeeded"
+	jobFailed    jobStatus = "failed"
+	jobCanceled  jobStatus = "canceled"
+	jobTimedOut  jobStatus = "timed_out"
+	// jobSkipped is only used for steps that were not run
+	// because an earlier step failed
+	jobSkipped jobStatus = "skipped"
+	// jobInterrupted is set for jobs that were queued or running
+	// when the server was stopped, and won't be resumed
+	jobInterrupted jobStatus = "interrupted"
+)
+
+// restartPolicy decides what happens to jobs that were queued or
+// running while the server was stopped.
+type restartPolicy string
+
+const (
+	// restartInterrupt marks unfinished jobs as interrupted. This
+	// is the default.
+	restartInterrupt restartPolicy = "interrupt"
+	// restartResume queues unfinished jobs again. Jobs that were
+	// already running are run again from the start.
+	restartResume restartPolicy = "resume"
+)
+
+const (
+	sourceHTTP     = "http"
+	sourceCLI      = "cli"
+	sourceSchedule = "schedule"
+	sourceChain    = "chain"
+	sourceRetry    = "retry"
+)
+
+const jobsBucket = "jobs"
+
+// jobData is the record of a single execution of a hook. One is
+// created for every trigger, regardless of where it came from.
+type jobData struct {
+	ID   uint64
+	App  string
+	Hook string
+	// Command is only set for jobs that were recorded before
+	// hooks could have several steps
+	Command string `json:",omitempty"`
+	// Steps reflect the current or last attempt of the job
+	Steps  []jobStep
+	Source string
+	// ParentID is set for jobs that were triggered as a
+	// follow-up of another job
+	ParentID uint64 `json:",omitempty"`
+	// RemoteAddr and DeliveryID are only set for jobs that
+	// were triggered over http
+	RemoteAddr string `json:",omitempty"`
+	DeliveryID string `json:",omitempty"`
+	// RetryOf is set for jobs that were started to retry a failed
+	// job by hand, and RetriedAs on the job that was retried
+	RetryOf   uint64 `json:",omitempty"`
+	RetriedAs uint64 `json:",omitempty"`
+	Status    jobStatus
+	// Timeout is the number of seconds the job may run
+	// for, or zero if it has no timeout
+	Timeout int `json:",omitempty"`
+	// Retry is the retry policy of the hook at the time the
+	// job was created
+	Retry retryPolicy
+
+	Created time.Time
+	// NotBefore is set for jobs that were deferred
+	NotBefore *time.Time   `json:",omitempty"`
+	Started   *time.Time   `json:",omitempty"`
+	Finished  *time.Time   `json:",omitempty"`
+	Output    string       `json:",omitempty"`
+	Error     string       `json:",omitempty"`
+	Attempts  []jobAttempt `json:",omitempty"`
+	// Callbacks are notified once the job is done
+	Callbacks []jobCallback `json:",omitempty"`
+	// Acked is set once a failed job was dismissed
+	Acked *time.Time `json:",omitempty"`
+	// FollowUps records the follow-up hooks that were triggered
+	// once the job was done, or why they couldn't be
+	FollowUps []jobFollowUp `json:",omitempty"`
+
+	// done is closed once the job has finished
+	done chan struct{}
+	// secret is the app secret the job was triggered with, which
+	// is used to sign callbacks. It is never stored.
+	secret string
+	// render returns the steps of the job for the given builtin
+	// variables. It is only set for jobs that were not stored yet,
+	// since their id is only known once they are.
+	render func(vars map[string]string) ([]jobStep, error)
+	// revision counts the snapshots that were taken of the job, and
+	// stored is the revision that was stored last, guarded by storeMu
+	revision uint64
+	stored   uint64
+}
+
+// jobTrigger describes where a job came from
+type jobTrigger struct {
+	Source string
+	// CallbackURL is notified once the job is done, with a
+	// signature using Secret
+	CallbackURL string
+	Secret      string
+	// Parent is the job that triggered this one, if any
+	Parent uint64
+	// RemoteAddr and DeliveryID describe http requests
+	RemoteAddr string
+	DeliveryID string
+}
+
+// jobFollowUp records the result of triggering a follow-up hook
+type jobFollowUp struct {
+	Hook  string
+	Job   uint64 `json:",omitempty"`
+	Error string `json:",omitempty"`
+}
+
+// jobAttempt records a single try at running the command of a job
+type jobAttempt struct {
+	Started  time.Time
+	Finished time.Time
+	Status   jobStatus
+	Failure  failureClass `json:",omitempty"`
+	Output   string       `json:",omitempty"`
+	Error    string       `json:",omitempty"`
+}
+
+// jobStep is the rendered command of a single step of a hook,
+// together with the result of running it.
+type jobStep struct {
+	Command string
+	// Argv contains the arguments of the command, which is
+	// only stored for steps of jobs that were rendered
+	// from arguments
+	Argv     []string   `json:",omitempty"`
+	Always   bool       `json:",omitempty"`
+	Status   jobStatus  `json:",omitempty"`
+	Started  *time.Time `json:",omitempty"`
+	Finished *time.Time `json:",omitempty"`
+	Output   string     `json:",omitempty"`
+	Error    string     `json:",omitempty"`
+
+	// failure is only known while the attempt is running
+	failure failureClass
+}
+
+// StepOutput returns the output of a step the way it is shown as part
+// of the output of the job. For jobs with several steps, it starts
+// with a header naming the step.
+func (j jobData) StepOutput(i int) string {
+	step := j.Steps[i]
+	if len(j.Steps) == 1 {
+		return step.Output
+	}
+
+	header := fmt.Sprintf("-----> step %d: %s", i+1, step.Command)
+	if step.Status == jobSkipped {
+		header = fmt.Sprintf("%s (skipped)", header)
+	}
+
+	if len(step.Output) == 0 {
+		return header
+	}
+
+	return fmt.Sprintf("%s\n%s", header, step.Output)
+}
+
+func (j jobData) IDString() string {
+	return strconv.FormatUint(j.ID, 10)
+}
+
+func (j jobData) Done() bool {
+	switch j.Status {
+	case jobSucceeded, jobFailed, jobCanceled, jobTimedOut, jobInterrupted:
+		return true
+	}
+
+	return false
+}
+
+// Failed checks whether the job ended in a failure that
+// someone should look at.
+func (j jobData) Failed() bool {
+	return j.Status == jobFailed || j.Status == jobTimedOut
+}
+
+func (j jobData) Duration() time.Duration {
+	if j.Started == nil {
+		return 0
+	}
+
+	if j.Finished == nil {
+		return time.Since(*j.Started)
+	}
+
+	return j.Finished.Sub(*j.Started)
+}
+
+// startJob renders the command for a hook and submits a new job for it.
+// If the trigger was coalesced into a job that is still waiting to be
+// run, that job is returned instead.
+func startJob(app string, hook hookData, params map[string]string, trigger jobTrigger) (*jobData, triggerOutcome, error) {
+	job := &jobData{
+		App:        app,
+		Hook:       hook.Name,
+		Source:     trigger.Source,
+		ParentID:   trigger.Parent,
+		RemoteAddr: trigger.RemoteAddr,
+		DeliveryID: trigger.DeliveryID,
+		Status:     jobQueued,
+		Created:    time.Now(),
+		Timeout:    int(hook.timeout().Seconds()),
+		Retry:      hook.Retry,
+		done:       make(chan struct{}),
+		secret:     trigger.Secret,
+	}
+
+	job.render = func(vars map[string]string) ([]jobStep, error) {
+		return hook.GetSteps(withVariables(params, vars))
+	}
+
+	// NOTE(happens): The steps are rendered again once the job
+	// has an id, this only makes sure that the parameters are valid
+	steps, err := job.render(job.Variables())
+	if err != nil {
+		return nil, "", err
+	}
+
+	job.Steps = steps
+
+	if len(trigger.CallbackURL) > 0 {
+		job.Callbacks = []jobCallback{{URL: trigger.CallbackURL}}
+	}
+
+	return submitJob(job, hook)
+}
+
+// runJob runs a job until it is finished, or until ctx is canceled.
+// The job has to have a worker of q, which it gives up while waiting
+// for a retry.
+func runJob(ctx context.Context, q *appQueue, job *jobData) {
+	defer close(job.done)
+
+	started := time.Now()
+	job.Status = jobRunning
+	job.Started = &started
+	if err := saveJob(job); err != nil {
+		fmt.Printf("unable to save job %d: %v\n", job.ID, err)
+	}
+
+	publishJob(job.ID, jobEvent{Status: jobRunning})
+
+	if err := touchHook(job.App, job.Hook, started); err != nil {
+		fmt.Printf("unable to update hook %s/%s: %v\n", job.App, job.Hook, err)
+	}
+
+	fmt.Printf("executing job %d with %d step(s)\n", job.ID, len(job.Steps))
+
+	var last jobAttempt
+	for {
+		last = runAttempt(ctx, job)
+		job.Attempts = append(job.Attempts, last)
+
+		attempts := len(job.Attempts)
+		if last.Status != jobFailed || !job.Retry.ShouldRetry(attempts, last.Failure) {
+			break
+		}
+
+		if err := saveJob(job); err != nil {
+			fmt.Printf("unable to save job %d: %v\n", job.ID, err)
+		}
+
+		delay := job.Retry.Delay(attempts)
+		retrying := fmt.Sprintf("attempt %d failed: %s, retrying in %s", attempts, last.Error, delay)
+		publishJob(job.ID, jobEvent{Error: retrying})
+		fmt.Printf("job %d %s\n", job.ID, retrying)
+
+		// NOTE(happens): Jobs of other apps can use the worker in
+		// the meantime, the jobs of this app still have to wait
+		// unless they are allowed to run in parallel
+		q.releaseWorker(job)
+
+		select {
+		case <-time.After(delay):
+			if err := q.acquireWorker(ctx, job); err == nil {
+				continue
+			}
+		case <-ctx.Done():
+		}
+
+		// NOTE(happens): The job was canceled while waiting
+		// for the next attempt
+		last = jobAttempt{Status: jobCanceled, Error: "canceled while waiting for a retry"}
+		break
+	}
+
+	finished := time.Now()
+	job.Finished = &finished
+	job.Status = last.Status
+	job.Error = last.Error
+	if len(last.Output) > 0 {
+		job.Output = last.Output
+	}
+
+	if job.Status == jobFailed && len(job.Attempts) > 1 {
+		job.Error = fmt.Sprintf("%s, giving up after %d attempts", job.Error, len(job.Attempts))
+	}
+
+	if job.Status == jobSucceeded {
+		success := finished.Unix()
+		err := updateHook(job.App, job.Hook, func(h *hookData) error {
+			h.LastSuccess = &success
+			return nil
+		})
+
+		if err != nil {
+			fmt.Printf("unable to update hook %s/%s: %v\n", job.App, job.Hook, err)
+		}
+	}
+
+	if err := saveJob(job); err != nil {
+		fmt.Printf("unable to save job %d: %v\n", job.ID, err)
+	}
+
+	publishJob(job.ID, jobEvent{Status: job.Status, Error: job.Error})
+	closeWatchers(job.ID)
+	sendCallbacks(job)
+	notifyJob(job)
+	chainJob(job)
+
+	fmt.Printf("job %d %s after %s\n", job.ID, job.Status, job.Duration())
+}
+
+// runAttempt runs the steps of a job once, until the first one fails.
+// Steps that are marked as always run are run regardless, unless the
+// job was canceled or timed out. Steps that succeeded in an earlier
+// attempt are not run again, so retries resume at the failed step.
+func runAttempt(ctx context.Context, job *jobData) jobAttempt {
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		timeout := time.Duration(job.Timeout) * time.Second
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	attempt := jobAttempt{Started: time.Now(), Status: jobSucceeded}
+	outputs := []string{}
+
+	resume := 0
+	for resume < len(job.Steps) && job.Steps[resume].Status == jobSucceeded {
+		if output := job.StepOutput(resume); len(output) > 0 {
+			outputs = append(outputs, output)
+		}
+
+		resume++
+	}
+
+	for i := resume; i < len(job.Steps); i++ {
+		step := &job.Steps[i]
+		*step = jobStep{Command: step.Command, Argv: step.Argv, Always: step.Always}
+
+		failed := attempt.Status != jobSucceeded
+		if (failed && !step.Always) || ctx.Err() != nil {
+			step.Status = jobSkipped
+		} else {
+			runStep(ctx, job, step)
+			if err := saveJob(job); err != nil {
+				fmt.Printf("unable to save job %d: %v\n", job.ID, err)
+			}
+		}
+
+		output := job.StepOutput(i)
+		if len(output) > 0 {
+			outputs = append(outputs, output)
+			publishJob(job.ID, jobEvent{Output: output})
+		}
+
+		if failed || step.Status == jobSucceeded || step.Status == jobSkipped {
+			continue
+		}
+
+		attempt.Status = step.Status
+		attempt.Failure = step.failure
+		attempt.Error = step.Error
+		if len(job.Steps) > 1 {
+			attempt.Error = fmt.Sprintf("step %d: %s", i+1, step.Error)
+		}
+	}
+
+	attempt.Finished = time.Now()
+	attempt.Output = truncateOutput(strings.Join(outputs, "\n"))
+	return attempt
+}
+
+// runStep sends the command of a single step to the dokku daemon.
+func runStep(ctx context.Context, job *jobData, step *jobStep) {
+	started := time.Now()
+	step.Started = &started
+
+	result, err := sendDokkuCmd(ctx, step.Command)
+	finished := time.Now()
+	step.Finished = &finished
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		step.Status = jobTimedOut
+		step.Error = fmt.Sprintf("timed out after %ds", job.Timeout)
+	case ctx.Err() == context.Canceled:
+		step.Status = jobCanceled
+		step.Error = "canceled while running"
+	case err != nil:
+		step.Status = jobFailed
+		step.failure = failureUnknown
+		if _, ok := err.(notSentError); ok {
+			step.failure = failureConnection
+		}
+
+		step.Error = err.Error()
+	case !result.Ok:
+		step.Status = jobFailed
+		step.failure = failureCommand
+		step.Output = truncateOutput(result.Output)
+		step.Error = "command failed"
+	default:
+		step.Status = jobSucceeded
+		step.Output = truncateOutput(result.Output)
+	}
+}
+
+// abortJob finishes a job that will never be run.
+func abortJob(job *jobData, status jobStatus, reason string) {
+	finished := time.Now()
+	job.Status = status
+	job.Finished = &finished
+	job.Error = reason
+
+	if err := saveJob(job); err != nil {
+		fmt.Printf("unable to save job %d: %v\n", job.ID, err)
+	}
+
+	publishJob(job.ID, jobEvent{Status: status, Error: reason})
+	closeWatchers(job.ID)
+	sendCallbacks(job)
+	notifyJob(job)
+
+	fmt.Printf("job %d %s: %s\n", job.ID, status, reason)
+	close(job.done)
+}
+
+// recoverJobs handles all jobs that were not finished when the server
+// was last stopped, according to the restart policy of their hook. This
+// has to be called before any new jobs are accepted.
+func recoverJobs() error {
+	unfinished := []*jobData{}
+
+	err := jobStorage.View(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket([]byte(jobsBucket))
+		atomic.StoreUint64(&lastJobID, jobs.Sequence())
+
+		return jobs.ForEach(func(k []byte, v []byte) error {
+			var job jobData
+			if err := json.Unmarshal(v, &job); err != nil {
+				return nil
+			}
+
+			if !job.Done() {
+				unfinished = append(unfinished, &job)
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	// NOTE(happens): Jobs are iterated in the order they were
+	// created, so resumed jobs keep their order in the queue
+	for _, job := range unfinished {
+		job.done = make(chan struct{})
+		if len(job.Steps) == 0 {
+			job.Steps = []jobStep{{Command: job.Command}}
+			job.Command = ""
+		}
+
+		hook, err := loadHook(job.App, job.Hook)
+		if err != nil {
+			abortJob(job, jobInterrupted, "interrupted by server restart, hook no longer exists")
+			continue
+		}
+
+		if hook.OnRestart != restartResume {
+			abortJob(job, jobInterrupted, "interrupted by server restart")
+			continue
+		}
+
+		if job.Status == jobRunning {
+			job.Attempts = append(job.Attempts, jobAttempt{
+				Started:  *job.Started,
+				Finished: time.Now(),
+				Status:   jobInterrupted,
+				Error:    "interrupted by server restart",
+			})
+		}
+
+		job.Status = jobQueued
+		job.Started = nil
+		if err := saveJob(job); err != nil {
+			return err
+		}
+
+		fmt.Printf("resuming job %d for %s/%s\n", job.ID, job.App, job.Hook)
+		resumeJob(job, hook.concurrency())
+	}
+
+	return nil
+}
+
+// lastJobID is the id that was assigned last. Ids are assigned before
+// jobs are stored, so that they can be stored without holding queuesMu.
+var lastJobID uint64
+
+// assignJobID assigns the next free id to a job, and renders its steps
+// since they can use the id.
+func assignJobID(job *jobData) error {
+	job.ID = atomic.AddUint64(&lastJobID, 1)
+	if job.render == nil {
+		return nil
+	}
+
+	steps, err := job.render(job.Variables())
+	if err != nil {
+		return err
+	}
+
+	job.Steps = steps
+	return nil
+}
+
+// storeMu guards the stored revision of every job, so that a snapshot
+// is never stored over a newer one of the same job.
+var storeMu sync.Mutex
+
+// jobSnapshot is the state of a job at one point in time, which can be
+// stored once the lock that guards the job has been released.
+type jobSnapshot struct {
+	job      *jobData
+	revision uint64
+	ser      []byte
+}
+
+// snapshot takes a snapshot of the job. Snapshots of the same job
+// must not be taken at the same time.
+func (job *jobData) snapshot() (jobSnapshot, error) {
+	ser, err := json.Marshal(job)
+	if err != nil {
+		e := fmt.Sprintf("failed to serialize job: %v", err)
+		return jobSnapshot{}, errors.New(e)
+	}
+
+	job.revision++
+	return jobSnapshot{job: job, revision: job.revision, ser: ser}, nil
+}
+
+// store saves a snapshot, unless a newer snapshot of the
+// same job has already been stored.
+func (s jobSnapshot) store() error {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+
+	if s.revision <= s.job.stored {
+		return nil
+	}
+
+	err := jobStorage.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket([]byte(jobsBucket))
+		if err := jobs.Put(jobKey(s.job.ID), s.ser); err != nil {
+			e := fmt.Sprintf("unable to save job: %v", err)
+			return errors.New(e)
+		}
+
+		// NOTE(happens): The sequence is only used to pick up the
+		// ids where they left off after a restart
+		if s.job.ID > jobs.Sequence() {
+			return jobs.SetSequence(s.job.ID)
+		}
+
+		return nil
+	})
+
+	if err == nil {
+		s.job.stored = s.revision
+	}
+
+	return err
+}
+
+func saveJob(job *jobData) error {
+	snapshot, err := job.snapshot()
+	if err != nil {
+		return err
+	}
+
+	return snapshot.store()
+}
+
+// updateJob loads a stored job, applies fn to it and saves it again,
+// all in a single transaction.
+func updateJob(id uint64, fn func(job *jobData) error) error {
+	return jobStorage.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket([]byte(jobsBucket))
+
+		foundRaw := jobs.Get(jobKey(id))
+		if foundRaw == nil {
+			e := fmt.Sprintf("job %d does not exist", id)
+			return errors.New(e)
+		}
+
+		var found jobData
+		if err := json.Unmarshal(foundRaw, &found); err != nil {
+			e := fmt.Sprintf("error reading job data: %v", err)
+			return errors.New(e)
+		}
+
+		if err := fn(&found); err != nil {
+			return err
+		}
+
+		return putJob(jobs, &found)
+	})
+}
+
+func getJob(id uint64) (*jobData, error) {
+	var found jobData
+	err := jobStorage.View(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket([]byte(jobsBucket))
+
+		foundRaw := jobs.Get(jobKey(id))
+		if foundRaw == nil {
+			e := fmt.Sprintf("job %d does not exist", id)
+			return errors.New(e)
+		}
+
+		err := json.Unmarshal(foundRaw, &found)
+		if err != nil {
+			e := fmt.Sprintf("error reading job data: %v", err)
+			return errors.New(e)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &found, nil
+}
+
+// listJobs returns the most recent jobs for an app, newest first. If hook
+// is empty, jobs for all hooks of the app are returned.
+func listJobs(app, hook string, limit int) ([]jobData, error) {
+	result := []jobData{}
+
+	err := jobStorage.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(jobsBucket)).Cursor()
+
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(result) >= limit {
+				return nil
+			}
+
+			var job jobData
+			if err := json.Unmarshal(v, &job); err != nil {
+				// NOTE(happens): Skip broken records instead of failing
+				// the whole listing
+				continue
+			}
+
+			if job.App != app || (len(hook) > 0 && job.Hook != hook) {
+				continue
+			}
+
+			result = append(result, job)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+func putJob(jobs *bolt.Bucket, job *jobData) error {
+	ser, err := json.Marshal(job)
+	if err != nil {
+		e := fmt.Sprintf("failed to serialize job: %v", err)
+		return errors.New(e)
+	}
+
+	err = jobs.Put(jobKey(job.ID), ser)
+	if err != nil {
+		e := fmt.Sprintf("unable to save job: %v", err)
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+// jobKey encodes job IDs big endian, so that bolt keeps
+// them sorted in the order they were created.
+func jobKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
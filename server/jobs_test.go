@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestRecoverJobs(t *testing.T) {
+	resetTestStorage(t)
+
+	// NOTE(happens): The queue is marked busy, so that resumed jobs
+	// are only queued and not run
+	queues["foo"] = newTestQueue()
+	defer func() { queues = make(map[string]*appQueue) }()
+
+	putTestHook(t, "foo", hookData{Name: "resume", OnRestart: restartResume})
+	putTestHook(t, "foo", hookData{Name: "interrupt"})
+
+	started := time.Now().Add(-time.Minute)
+	later := time.Now().Add(time.Hour)
+	finished := time.Now()
+
+	stored := []jobData{
+		{ID: 1, App: "foo", Hook: "resume", Status: jobRunning, Started: &started, Command: "ps:restart"},
+		{ID: 2, App: "foo", Hook: "resume", Status: jobQueued, Steps: []jobStep{{Command: "ps:restart"}}},
+		{ID: 3, App: "foo", Hook: "resume", Status: jobQueued, NotBefore: &later},
+		{ID: 4, App: "foo", Hook: "interrupt", Status: jobRunning, Started: &started},
+		{ID: 5, App: "foo", Hook: "deleted", Status: jobQueued},
+		{ID: 6, App: "foo", Hook: "resume", Status: jobSucceeded, Started: &started, Finished: &finished},
+	}
+
+	err := jobStorage.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket([]byte(jobsBucket))
+		for i := range stored {
+			if err := putJob(jobs, &stored[i]); err != nil {
+				return err
+			}
+		}
+
+		return jobs.SetSequence(7)
+	})
+
+	if err != nil {
+		t.Fatalf("could not store jobs: %v", err)
+	}
+
+	if err := recoverJobs(); err != nil {
+		t.Fatalf("recoverJobs failed: %v", err)
+	}
+
+	if lastJobID != 7 {
+		t.Errorf("lastJobID = %d, want 7", lastJobID)
+	}
+
+	q := queues["foo"]
+	pending := []uint64{}
+	for _, job := range q.pending {
+		pending = append(pending, job.ID)
+	}
+
+	if !equalIDs(pending, []uint64{1, 2}) {
+		t.Errorf("pending = %v, want [1 2]", pending)
+	}
+
+	if delayed, ok := q.delayed["resume"]; !ok || delayed.ID != 3 {
+		t.Errorf("job 3 should be deferred")
+	}
+
+	cases := []struct {
+		id       uint64
+		status   jobStatus
+		attempts int
+		steps    int
+	}{
+		// NOTE(happens): The interrupted run is recorded as
+		// an attempt of the resumed job
+		{1, jobQueued, 1, 1},
+		{2, jobQueued, 0, 1},
+		{3, jobQueued, 0, 0},
+		{4, jobInterrupted, 0, 0},
+		{5, jobInterrupted, 0, 0},
+		{6, jobSucceeded, 0, 0},
+	}
+
+	for _, c := range cases {
+		job, err := getJob(c.id)
+		if err != nil {
+			t.Errorf("could not load job %d: %v", c.id, err)
+			continue
+		}
+
+		if job.Status != c.status {
+			t.Errorf("job %d: status = %s, want %s", c.id, job.Status, c.status)
+		}
+
+		if len(job.Attempts) != c.attempts {
+			t.Errorf("job %d: %d attempts, want %d", c.id, len(job.Attempts), c.attempts)
+		}
+
+		if c.status == jobQueued && job.Started != nil {
+			t.Errorf("job %d: resumed job should not be started", c.id)
+		}
+
+		if c.steps > 0 && len(job.Steps) != c.steps {
+			t.Errorf("job %d: %d steps, want %d", c.id, len(job.Steps), c.steps)
+		}
+	}
+}
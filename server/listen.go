@@ -10,8 +10,8 @@ import (
 	"os"
 	"os/signal"
 	"os/user"
-	"regexp"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -22,7 +22,8 @@ import (
 	webhooks "github.com/happenslol/dokku-webhooks"
 )
 
-var argsRegex = regexp.MustCompile("\\#[a-zA-Z0-9-_.]+")
+// logsLimit is the maximum number of jobs listed by CmdLogs
+const logsLimit = 25
 
 func listen() {
 	usr, _ := user.Lookup("root")
@@ -114,7 +115,9 @@ func handleClient(c net.Conn, done chan<- bool) {
 				return nil
 			}
 
-			data := []string{"NAME | COMMAND | LAST ACTIVATION"}
+			data := []string{"NAME | COMMAND | OPTIONS | LAST ACTIVATION"}
+			argv := []string{"NAME | STEP | ARGUMENTS"}
+			params := []string{"NAME\x1fPARAMETER\x1fSOURCE\x1fTYPE\x1fDEFAULT\x1fALLOWED\x1fDESCRIPTION"}
 			_ = appBucket.ForEach(func(k []byte, v []byte) error {
 				var hook hookData
 				if err := json.Unmarshal(v, &hook); err != nil {
@@ -130,15 +133,55 @@ func handleClient(c net.Conn, done chan<- bool) {
 				}
 
 				data = append(data, fmt.Sprintf(
-					"%s | %s | %s",
+					"%s | %s | %s | %s",
 					hook.Name,
-					hook.CommandTemplate,
+					hook.Commands(),
+					hook.Options(),
 					timeStr,
 				))
+
+				for i, step := range hook.steps() {
+					argv = append(argv, fmt.Sprintf("%s | %d | %s", hook.Name, i+1, formatArgv(step.argv())))
+				}
+
+				for _, arg := range hook.Args {
+					if isBuiltin(arg) {
+						continue
+					}
+
+					decl := hook.param(arg)
+					def := "required"
+					if decl.Default != nil {
+						def = strconv.Quote(*decl.Default)
+					}
+
+					desc := decl.Description
+					if len(desc) == 0 {
+						desc = "-"
+					}
+
+					params = append(params, strings.Join([]string{
+						hook.Name,
+						arg,
+						hook.source(arg),
+						string(decl.paramType()),
+						def,
+						decl.Allowed(),
+						desc,
+					}, "\x1f"))
+				}
+
 				return nil
 			})
 
-			result := columnize.SimpleFormat(data)
+			result := fmt.Sprintf("%s\n\n%s", columnize.SimpleFormat(data), columnize.SimpleFormat(argv))
+			if len(params) > 1 {
+				// NOTE(happens): Patterns often contain pipes, which
+				// would be read as column delimiters otherwise
+				config := columnize.DefaultConfig()
+				config.Delim = "\x1f"
+				result = fmt.Sprintf("%s\n\n%s", result, columnize.Format(params, config))
+			}
 			res.Ok(result)
 			return nil
 		})
@@ -221,11 +264,26 @@ func handleClient(c net.Conn, done chan<- bool) {
 				return errors.New(e)
 			}
 
-			hookArgs := argsRegex.FindAllString(command, -1)
-			hookObj := hookData{
-				Name:            hook,
-				CommandTemplate: command,
-				Args:            hookArgs,
+			hookObj := hookData{Name: hook}
+			if err := hookObj.SetCommands(command); err != nil {
+				return err
+			}
+
+			if len(hookObj.Steps) == 0 {
+				e := "a hook needs at least one command"
+				return errors.New(e)
+			}
+
+			if err := hookObj.SetOptions(cmd.Args[3:]); err != nil {
+				return err
+			}
+
+			if err := hookObj.checkParams(); err != nil {
+				return err
+			}
+
+			if err := checkChain(tx, app, hookObj); err != nil {
+				return err
 			}
 
 			ser, err := json.Marshal(hookObj)
@@ -250,6 +308,46 @@ func handleClient(c net.Conn, done chan<- bool) {
 		}
 		return
 
+	case webhooks.CmdUpdate:
+		fmt.Printf("running CmdUpdate with args %v\n", cmd.Args)
+		app, hook, command := cmd.Args[0], cmd.Args[1], cmd.Args[2]
+
+		err := editHook(app, hook, func(tx *bolt.Tx, h *hookData) error {
+			if len(command) > 0 {
+				if err := h.SetCommands(command); err != nil {
+					return err
+				}
+
+				if len(h.Steps) == 0 {
+					e := "a hook needs at least one command"
+					return errors.New(e)
+				}
+			}
+
+			if err := h.SetOptions(cmd.Args[3:]); err != nil {
+				return err
+			}
+
+			if err := h.checkParams(); err != nil {
+				return err
+			}
+
+			if err := checkChain(tx, app, *h); err != nil {
+				return err
+			}
+
+			return checkReferrers(tx, app, *h)
+		})
+
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		result := fmt.Sprintf("webhook %s/%s updated", app, hook)
+		res.Ok(result)
+		return
+
 	case webhooks.CmdDelete:
 		fmt.Printf("running CmdDelete with args %v\n", cmd.Args)
 		app, hook := cmd.Args[0], cmd.Args[1]
@@ -268,7 +366,20 @@ func handleClient(c net.Conn, done chan<- bool) {
 				return nil
 			}
 
-			err := appBucket.Delete([]byte(hook))
+			refs, err := referrers(tx, app, hook)
+			if err != nil {
+				return err
+			}
+
+			if len(refs) > 0 {
+				e := fmt.Sprintf(
+					"hook %s/%s is a follow-up of %s, remove it from their --on-success and --on-failure first",
+					app, hook, strings.Join(refs, ", "),
+				)
+				return errors.New(e)
+			}
+
+			err = appBucket.Delete([]byte(hook))
 			if err != nil {
 				e := fmt.Sprintf("failed to delete hook: %v", err)
 				return errors.New(e)
@@ -379,51 +490,499 @@ func handleClient(c net.Conn, done chan<- bool) {
 		fmt.Printf("running CmdTrigger with args %v\n", cmd.Args)
 		app, hook := cmd.Args[0], cmd.Args[1]
 
-		var found hookData
-		err := hookStorage.View(func(tx *bolt.Tx) error {
-			appBucketStr := fmt.Sprintf("app/%s", app)
-			appBucket := tx.Bucket([]byte(appBucketStr))
-			if appBucket == nil {
-				return errors.New("hook does not exist")
+		found, err := loadHook(app, hook)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		params, err := parseParams(app, cmd.Args[2:])
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		job, outcome, err := startJob(app, found, params, jobTrigger{Source: sourceCLI})
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		result := fmt.Sprintf("accepted (%s), job id: %d", outcome, job.ID)
+		if job.NotBefore != nil {
+			scheduled := formatTime(job.NotBefore)
+			result = fmt.Sprintf("%s, scheduled for %s", result, scheduled)
+		}
+
+		res.Ok(result)
+		return
+
+	case webhooks.CmdLogs:
+		fmt.Printf("running CmdLogs with args %v\n", cmd.Args)
+		app, hook := cmd.Args[0], ""
+		if len(cmd.Args) > 1 {
+			hook = cmd.Args[1]
+		}
+
+		if len(cmd.Args) > 2 {
+			id, err := strconv.ParseUint(cmd.Args[2], 10, 64)
+			if err != nil {
+				e := fmt.Sprintf("invalid job id: %s", cmd.Args[2])
+				res.Fail(errors.New(e))
+				return
 			}
 
-			foundRaw := appBucket.Get([]byte(hook))
-			if foundRaw == nil {
-				return errors.New("hook does not exist")
+			job, err := getJob(id)
+			if err != nil {
+				res.Fail(err)
+				return
+			}
+
+			if job.App != app || job.Hook != hook {
+				e := fmt.Sprintf("job %d does not belong to %s/%s", id, app, hook)
+				res.Fail(errors.New(e))
+				return
+			}
+
+			res.Ok(formatJob(*job))
+			return
+		}
+
+		jobs, err := listJobs(app, hook, logsLimit)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		if len(jobs) == 0 {
+			res.Ok("no activations recorded")
+			return
+		}
+
+		data := []string{"ID | HOOK | SOURCE | STATUS | STARTED | DURATION"}
+		for _, job := range jobs {
+			data = append(data, fmt.Sprintf(
+				"%d | %s | %s | %s | %s | %s",
+				job.ID,
+				job.Hook,
+				job.Source,
+				job.Status,
+				formatTime(job.Started),
+				formatDuration(job),
+			))
+		}
+
+		res.Ok(columnize.SimpleFormat(data))
+		return
+
+	case webhooks.CmdCancel:
+		fmt.Printf("running CmdCancel with args %v\n", cmd.Args)
+		app := cmd.Args[0]
+
+		id, err := strconv.ParseUint(cmd.Args[1], 10, 64)
+		if err != nil {
+			e := fmt.Sprintf("invalid job id: %s", cmd.Args[1])
+			res.Fail(errors.New(e))
+			return
+		}
+
+		if err := cancelJob(app, id); err != nil {
+			res.Fail(err)
+			return
+		}
+
+		result := fmt.Sprintf("job %d canceled", id)
+		res.Ok(result)
+		return
+
+	case webhooks.CmdFollow:
+		fmt.Printf("running CmdFollow with args %v\n", cmd.Args)
+		app, hook := cmd.Args[0], cmd.Args[1]
+
+		var id uint64
+		if len(cmd.Args) > 2 {
+			parsed, err := strconv.ParseUint(cmd.Args[2], 10, 64)
+			if err != nil {
+				e := fmt.Sprintf("invalid job id: %s", cmd.Args[2])
+				res.Fail(errors.New(e))
+				return
 			}
 
-			err := json.Unmarshal(foundRaw, &found)
+			id = parsed
+		} else {
+			latest, err := listJobs(app, hook, 1)
 			if err != nil {
-				e := fmt.Sprintf("error reading hook data: %v, data:%v", err, foundRaw)
-				return errors.New(e)
+				res.Fail(err)
+				return
 			}
 
-			return nil
-		})
+			if len(latest) == 0 {
+				res.Fail(errors.New("no activations recorded"))
+				return
+			}
+
+			id = latest[0].ID
+		}
+
+		job, err := getJob(id)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		if job.App != app || job.Hook != hook {
+			e := fmt.Sprintf("job %d does not belong to %s/%s", id, app, hook)
+			res.Fail(errors.New(e))
+			return
+		}
 
+		job, err = followJob(c, id)
 		if err != nil {
 			res.Fail(err)
 			return
 		}
 
-		params := make(map[string]string)
-		params["app"] = app
-		cmd, err := found.GetCmd(params)
+		result := fmt.Sprintf("job %d %s", job.ID, job.Status)
+		if len(job.Error) > 0 {
+			result = fmt.Sprintf("%s: %s", result, job.Error)
+		}
+
+		if job.Status != jobSucceeded {
+			res.Fail(errors.New(result))
+			return
+		}
+
+		res.Ok(result)
+		return
+
+	case webhooks.CmdPrune:
+		fmt.Printf("running CmdPrune with args %v\n", cmd.Args)
+		app := ""
+		if len(cmd.Args) > 0 {
+			app = cmd.Args[0]
+		}
+
+		result, err := pruneJobs(app)
 		if err != nil {
+			e := fmt.Sprintf("failed to prune jobs: %v", err)
+			res.Fail(errors.New(e))
+			return
+		}
+
+		summary := fmt.Sprintf(
+			"removed %d jobs and truncated the output of %d, freed %s",
+			result.Deleted, result.Truncated, formatBytes(result.Bytes),
+		)
+		res.Ok(summary)
+		return
+
+	case webhooks.CmdScheduleAdd:
+		fmt.Printf("running CmdScheduleAdd with args %v\n", cmd.Args)
+		app, hook, spec := cmd.Args[0], cmd.Args[1], cmd.Args[2]
+
+		if err := addSchedule(app, hook, spec); err != nil {
 			res.Fail(err)
 			return
 		}
 
-		fmt.Printf("executing command: %s\n", cmd)
-		go sendDokkuCmd(cmd)
-		res.Ok("accepted")
+		result := fmt.Sprintf("schedule %s added to %s/%s", spec, app, hook)
+		res.Ok(result)
+		return
+
+	case webhooks.CmdScheduleRemove:
+		fmt.Printf("running CmdScheduleRemove with args %v\n", cmd.Args)
+		app, hook, spec := cmd.Args[0], cmd.Args[1], cmd.Args[2]
 
+		if err := removeSchedule(app, hook, spec); err != nil {
+			res.Fail(err)
+			return
+		}
+
+		result := fmt.Sprintf("schedule %s removed from %s/%s", spec, app, hook)
+		res.Ok(result)
 		return
 
-	case webhooks.CmdLogs:
-		fmt.Printf("running CmdLogs with args %v\n", cmd.Args)
-		res.Ok("not implemented")
+	case webhooks.CmdScheduleList:
+		fmt.Printf("running CmdScheduleList with args %v\n", cmd.Args)
+		app, hook := cmd.Args[0], cmd.Args[1]
+
+		found, err := loadHook(app, hook)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		if len(found.Schedules) == 0 {
+			res.Ok("no schedules for this hook")
+			return
+		}
+
+		data := []string{"# | SCHEDULE | LAST RUN | NEXT RUN"}
+		for i, s := range found.Schedules {
+			next := "-"
+			if cron, err := parseCron(s.Spec); err == nil {
+				t := cron.Next(time.Now())
+				next = formatTime(&t)
+			}
+
+			data = append(data, fmt.Sprintf(
+				"%d | %s | %s | %s",
+				i+1,
+				s.Spec,
+				formatTime(s.LastRun),
+				next,
+			))
+		}
+
+		result := columnize.SimpleFormat(data)
+		res.Ok(result)
+		return
+
+	case webhooks.CmdNotifyAdd:
+		fmt.Printf("running CmdNotifyAdd with args %v\n", cmd.Args)
+		app, name := cmd.Args[0], cmd.Args[1]
+		n := notifierData{
+			Name:    name,
+			Channel: notifyChannel(cmd.Args[2]),
+			Target:  cmd.Args[3],
+		}
+
+		for _, opt := range cmd.Args[4:] {
+			kv := strings.SplitN(opt, "=", 2)
+			if len(kv) != 2 {
+				e := fmt.Sprintf("invalid notifier option: %s", opt)
+				res.Fail(errors.New(e))
+				return
+			}
+
+			if err := n.SetOption(kv[0], kv[1]); err != nil {
+				res.Fail(err)
+				return
+			}
+		}
+
+		if err := n.Validate(); err != nil {
+			res.Fail(err)
+			return
+		}
+
+		if err := addNotifier(app, n); err != nil {
+			res.Fail(err)
+			return
+		}
+
+		result := fmt.Sprintf("notifier %s added to %s", name, app)
+		res.Ok(result)
+		return
+
+	case webhooks.CmdNotifyRemove:
+		fmt.Printf("running CmdNotifyRemove with args %v\n", cmd.Args)
+		app, name := cmd.Args[0], cmd.Args[1]
+
+		if err := removeNotifier(app, name); err != nil {
+			res.Fail(err)
+			return
+		}
+
+		result := fmt.Sprintf("notifier %s removed from %s", name, app)
+		res.Ok(result)
 		return
+
+	case webhooks.CmdNotifyList:
+		fmt.Printf("running CmdNotifyList with args %v\n", cmd.Args)
+		app := cmd.Args[0]
+
+		notifiers, err := listNotifiers(app)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		if len(notifiers) == 0 {
+			res.Ok("no notifiers for this app")
+			return
+		}
+
+		data := []string{"NAME | CHANNEL | TARGET | HOOK | ON | MESSAGE"}
+		for _, n := range notifiers {
+			hook := n.Hook
+			if len(hook) == 0 {
+				hook = "(all)"
+			}
+
+			events := []string{}
+			for _, ev := range n.events() {
+				events = append(events, string(ev))
+			}
+
+			message := n.Message
+			if len(message) == 0 {
+				message = "-"
+			}
+
+			data = append(data, fmt.Sprintf(
+				"%s | %s | %s | %s | %s | %s",
+				n.Name,
+				n.Channel,
+				n.Target,
+				hook,
+				strings.Join(events, ","),
+				message,
+			))
+		}
+
+		result := columnize.SimpleFormat(data)
+		res.Ok(result)
+		return
+
+	case webhooks.CmdNotifyTest:
+		fmt.Printf("running CmdNotifyTest with args %v\n", cmd.Args)
+		app, name := cmd.Args[0], cmd.Args[1]
+
+		notifiers, err := listNotifiers(app)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		for _, n := range notifiers {
+			if n.Name != name {
+				continue
+			}
+
+			job := jobResponse{App: app, Hook: n.Hook, Status: jobSucceeded}
+			if err := n.Send(eventTest, job); err != nil {
+				e := fmt.Sprintf("failed to send test notification: %v", err)
+				res.Fail(errors.New(e))
+				return
+			}
+
+			res.Ok("test notification sent")
+			return
+		}
+
+		e := fmt.Sprintf("app %s has no notifier named %s", app, name)
+		res.Fail(errors.New(e))
+		return
+
+	case webhooks.CmdRender:
+		fmt.Printf("running CmdRender with args %v\n", cmd.Args)
+		app, hook := cmd.Args[0], cmd.Args[1]
+
+		found, err := loadHook(app, hook)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		params, err := parseParams(app, cmd.Args[2:])
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		preview := jobData{App: app, Hook: found.Name, Source: sourceCLI, Created: time.Now()}
+		steps, err := found.GetSteps(withVariables(params, preview.Variables()))
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		lines := []string{}
+		for i, step := range steps {
+			line := fmt.Sprintf("%s\n   %s", step.Command, formatArgv(step.Argv))
+			if len(steps) > 1 {
+				line = fmt.Sprintf("%d. %s", i+1, line)
+			}
+
+			lines = append(lines, line)
+		}
+
+		res.Ok(strings.Join(lines, "\n"))
+		return
+
+	case webhooks.CmdStatus:
+		fmt.Printf("running CmdStatus with args %v\n", cmd.Args)
+		res.Ok(formatStatus())
+		return
+
+	case webhooks.CmdFailed:
+		fmt.Printf("running CmdFailed with args %v\n", cmd.Args)
+		app := ""
+		if len(cmd.Args) > 0 {
+			app = cmd.Args[0]
+		}
+
+		jobs, err := listFailedJobs(app)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		if len(jobs) == 0 {
+			res.Ok("no failed jobs")
+			return
+		}
+
+		data := []string{"ID | HOOK | SOURCE | STATUS | FINISHED | ERROR"}
+		for _, job := range jobs {
+			data = append(data, fmt.Sprintf(
+				"%d | %s/%s | %s | %s | %s | %s",
+				job.ID,
+				job.App,
+				job.Hook,
+				job.Source,
+				job.Status,
+				formatTime(job.Finished),
+				strings.SplitN(job.Error, "\n", 2)[0],
+			))
+		}
+
+		res.Ok(columnize.SimpleFormat(data))
+		return
+
+	case webhooks.CmdRetry:
+		fmt.Printf("running CmdRetry with args %v\n", cmd.Args)
+		id, err := strconv.ParseUint(cmd.Args[0], 10, 64)
+		if err != nil {
+			e := fmt.Sprintf("invalid job id: %s", cmd.Args[0])
+			res.Fail(errors.New(e))
+			return
+		}
+
+		job, outcome, err := retryJob(id)
+		if err != nil {
+			res.Fail(err)
+			return
+		}
+
+		result := fmt.Sprintf("accepted (%s), job id: %d retries job %d", outcome, job.ID, id)
+		res.Ok(result)
+		return
+
+	case webhooks.CmdAck:
+		fmt.Printf("running CmdAck with args %v\n", cmd.Args)
+		id, err := strconv.ParseUint(cmd.Args[0], 10, 64)
+		if err != nil {
+			e := fmt.Sprintf("invalid job id: %s", cmd.Args[0])
+			res.Fail(errors.New(e))
+			return
+		}
+
+		if err := ackJob(id); err != nil {
+			res.Fail(err)
+			return
+		}
+
+		result := fmt.Sprintf("job %d acknowledged", id)
+		res.Ok(result)
+		return
+
+	case webhooks.CmdInfo:
+		fmt.Printf("running CmdInfo with args %v\n", cmd.Args)
+		res.Ok(formatInfo())
+		return
+
 	case webhooks.CmdQuit:
 		fmt.Printf("running CmdQuit with args %v\n", cmd.Args)
 		res.Ok("shutting down")
@@ -442,6 +1001,71 @@ func acceptIncoming(sock net.Listener, cons chan<- net.Conn) {
 	}
 }
 
+// followJob sends the output and status changes of a job as partial
+// responses until the job is done, and returns its final state.
+func followJob(c net.Conn, id uint64) (*jobData, error) {
+	events, unwatch := watchJob(id)
+	defer unwatch()
+
+	// NOTE(happens): We have to load the job after subscribing,
+	// otherwise we could miss it finishing in between
+	job, err := getJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	backlog := jobBacklog(job)
+	header := fmt.Sprintf("-----> job %d for %s/%s: %s", job.ID, job.App, job.Hook, job.Status)
+	if err := sendPartial(c, header); err != nil {
+		return nil, err
+	}
+
+	for _, ev := range backlog[1:] {
+		if err := sendPartial(c, ev.Output); err != nil {
+			return nil, err
+		}
+	}
+
+	if job.Done() {
+		return job, nil
+	}
+
+	for ev := range events {
+		var msg string
+		switch {
+		case len(ev.Output) > 0:
+			msg = ev.Output
+		case len(ev.Status) > 0 && (jobData{Status: ev.Status}).Done():
+			// NOTE(happens): The final status is sent in
+			// the last response
+			continue
+		case len(ev.Status) > 0:
+			msg = fmt.Sprintf("-----> %s", ev.Status)
+		default:
+			msg = fmt.Sprintf("-----> %s", ev.Error)
+		}
+
+		if err := sendPartial(c, msg); err != nil {
+			return nil, err
+		}
+	}
+
+	return getJob(id)
+}
+
+func sendPartial(c net.Conn, content string) error {
+	var msg webhooks.Response
+	msg.Partial(content)
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.Write(encoded)
+	return err
+}
+
 func sendEncoded(c net.Conn, msg *webhooks.Response) {
 	encoded, _ := json.Marshal(msg)
 	c.Write(encoded)
@@ -463,7 +1087,212 @@ func genSecret(length int) (string, error) {
 		// Make sure that the number/byte/letter is inside
 		// the range of printable ASCII characters (excluding space and DEL)
 		if n > 32 && n < 127 {
-			result += string(n)
+			result += string(rune(n))
+		}
+	}
+}
+
+func formatJob(job jobData) string {
+	data := []string{
+		fmt.Sprintf("Job: | %d", job.ID),
+		fmt.Sprintf("Hook: | %s/%s", job.App, job.Hook),
+		fmt.Sprintf("Source: | %s", job.Source),
+		fmt.Sprintf("Status: | %s", job.Status),
+		fmt.Sprintf("Created: | %s", formatTime(&job.Created)),
+		fmt.Sprintf("Started: | %s", formatTime(job.Started)),
+		fmt.Sprintf("Finished: | %s", formatTime(job.Finished)),
+		fmt.Sprintf("Duration: | %s", formatDuration(job)),
+	}
+
+	if job.ParentID > 0 {
+		data = append(data, fmt.Sprintf("Parent: | %d", job.ParentID))
+	}
+
+	if job.RetryOf > 0 {
+		data = append(data, fmt.Sprintf("Retry of: | %d", job.RetryOf))
+	}
+
+	if job.RetriedAs > 0 {
+		data = append(data, fmt.Sprintf("Retried as: | %d", job.RetriedAs))
+	}
+
+	if job.Acked != nil {
+		data = append(data, fmt.Sprintf("Acknowledged: | %s", formatTime(job.Acked)))
+	}
+
+	if job.NotBefore != nil {
+		data = append(data, fmt.Sprintf("Scheduled: | %s", formatTime(job.NotBefore)))
+	}
+
+	if len(job.Error) > 0 {
+		data = append(data, fmt.Sprintf("Error: | %s", job.Error))
+	}
+
+	if len(job.Command) > 0 {
+		data = append(data, fmt.Sprintf("Command: | %s", job.Command))
+	}
+
+	for i, step := range job.Steps {
+		desc := string(step.Status)
+		if len(desc) == 0 {
+			desc = "pending"
+		}
+
+		if len(step.Error) > 0 {
+			desc = fmt.Sprintf("%s: %s", desc, step.Error)
+		}
+
+		if step.Always {
+			desc = fmt.Sprintf("%s (always)", desc)
+		}
+
+		data = append(data, fmt.Sprintf("Step %d: | %s | %s", i+1, step.Command, desc))
+	}
+
+	for _, callback := range job.Callbacks {
+		desc := "pending"
+		if callback.Delivered != nil {
+			desc = fmt.Sprintf("delivered at %s", formatTime(callback.Delivered))
+		} else if n := len(callback.Deliveries); n > 0 {
+			last := callback.Deliveries[n-1]
+			desc = fmt.Sprintf("%d failed attempt(s), last: %s", n, last.Error)
 		}
+
+		data = append(data, fmt.Sprintf("Callback: | %s | %s", callback.URL, desc))
 	}
+
+	for _, followUp := range job.FollowUps {
+		desc := fmt.Sprintf("triggered job %d", followUp.Job)
+		if len(followUp.Error) > 0 {
+			desc = fmt.Sprintf("failed: %s", followUp.Error)
+		}
+
+		data = append(data, fmt.Sprintf("Follow-up: | %s | %s", followUp.Hook, desc))
+	}
+
+	if len(job.Attempts) > 1 {
+		for i, attempt := range job.Attempts {
+			desc := string(attempt.Status)
+			if len(attempt.Error) > 0 {
+				desc = fmt.Sprintf("%s: %s", desc, attempt.Error)
+			}
+
+			data = append(data, fmt.Sprintf(
+				"Attempt %d: | %s, %s",
+				i+1,
+				formatTime(&attempt.Started),
+				desc,
+			))
+		}
+	}
+
+	result := columnize.SimpleFormat(data)
+	if len(job.Output) > 0 {
+		result = fmt.Sprintf("%s\n\n%s", result, job.Output)
+	}
+
+	return result
+}
+
+// formatInfo describes the builtin variables.
+func formatInfo() string {
+	vars := []string{}
+	for _, v := range builtinVars {
+		vars = append(vars, fmt.Sprintf("%s | %s", v.Name, v.Description))
+	}
+
+	message := []string{}
+	for _, v := range messageVars {
+		message = append(message, fmt.Sprintf("%s | %s", v.Name, v.Description))
+	}
+
+	return strings.Join([]string{
+		"Variables set by the server, usable in commands and notification messages:",
+		columnize.SimpleFormat(vars),
+		"",
+		"Variables only usable in notification messages:",
+		columnize.SimpleFormat(message),
+		"",
+		fmt.Sprintf("Delivery ids are read from the first of these headers: %s", strings.Join(deliveryHeaders, ", ")),
+	}, "\n")
+}
+
+// formatStatus describes the usage of the worker pool and the
+// jobs of all apps that are not done.
+func formatStatus() string {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+
+	running, _ := poolUsage()
+	limit := func(n int) string {
+		if n == 0 {
+			return "unlimited"
+		}
+
+		return strconv.Itoa(n)
+	}
+
+	data := []string{
+		fmt.Sprintf("Workers: | %d busy of %s", running, limit(poolSize)),
+		fmt.Sprintf("Queue: | %d queued of %s", queuedJobs(), limit(queueSize)),
+	}
+	result := columnize.SimpleFormat(data)
+
+	apps := []string{"APP | RUNNING | WAITING | QUEUED | DEFERRED"}
+	for app, q := range queues {
+		if len(q.active) == 0 && len(q.pending) == 0 && len(q.delayed) == 0 {
+			continue
+		}
+
+		// NOTE(happens): Active jobs that don't have a worker are
+		// waiting for one, or for their next attempt
+		started := 0
+		for _, a := range q.active {
+			if a.working {
+				started++
+			}
+		}
+
+		apps = append(apps, fmt.Sprintf(
+			"%s | %d | %d | %d | %d",
+			app,
+			started,
+			len(q.active)-started,
+			len(q.pending),
+			len(q.delayed),
+		))
+	}
+
+	if len(apps) > 1 {
+		result = fmt.Sprintf("%s\n\n%s", result, columnize.SimpleFormat(apps))
+	}
+
+	return result
+}
+
+func formatBytes(n int) string {
+	switch {
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1024*1024))
+	case n >= 1024:
+		return fmt.Sprintf("%.1f KiB", float64(n)/1024)
+	}
+
+	return fmt.Sprintf("%d bytes", n)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func formatDuration(job jobData) string {
+	if job.Started == nil {
+		return "-"
+	}
+
+	return job.Duration().Round(time.Millisecond).String()
 }
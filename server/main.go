@@ -1,12 +1,13 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"strings"
 	"sync"
 
 	"github.com/boltdb/bolt"
@@ -17,36 +18,6 @@ var jobStorage *bolt.DB
 var hookStorage *bolt.DB
 var wg sync.WaitGroup
 
-type hookData struct {
-	Name            string
-	CommandTemplate string
-	Args            []string
-	LastActivation  *int64
-}
-
-func (h hookData) GetCmd(args map[string]string) (string, error) {
-	result := h.CommandTemplate
-	missing := []string{}
-
-	for _, arg := range h.Args {
-		val, ok := args[arg]
-		if !ok {
-			missing = append(missing, arg)
-			continue
-		}
-
-		result = strings.ReplaceAll(result, arg, val)
-	}
-
-	if len(missing) > 0 {
-		all := strings.Join(missing, ", ")
-		e := fmt.Sprintf("missing arguments: %s", all)
-		return "", errors.New(e)
-	}
-
-	return result, nil
-}
-
 const (
 	secretsBucket = "secrets"
 	enabledBucket = "enabled"
@@ -75,6 +46,9 @@ func main() {
 		}
 	}
 
+	loadConfig()
+	initPool()
+
 	var err error
 
 	jobStorage, err = bolt.Open(jobStoragePath, 0777, nil)
@@ -83,6 +57,11 @@ func main() {
 	}
 	defer jobStorage.Close()
 
+	_ = jobStorage.Update(func(tx *bolt.Tx) error {
+		tx.CreateBucketIfNotExists([]byte(jobsBucket))
+		return nil
+	})
+
 	hookStorage, err = bolt.Open(hookStoragePath, 0777, nil)
 	if err != nil {
 		log.Fatalf("error opening hook storage: %v\n", err)
@@ -95,6 +74,13 @@ func main() {
 		return nil
 	})
 
+	if err := recoverJobs(); err != nil {
+		log.Fatalf("error recovering unfinished jobs: %v\n", err)
+	}
+
+	go pruneJobsPeriodically()
+	go runSchedules()
+
 	wg.Add(2)
 
 	go serve()
@@ -103,16 +89,70 @@ func main() {
 	wg.Wait()
 }
 
-func sendDokkuCmd(cmd string) {
-	// TODO(happens): logging for this
-	c, err := net.Dial("unix", dokkuSocket)
+// dokkuResult is the response sent by the dokku daemon
+// after it has finished running a command.
+type dokkuResult struct {
+	Ok     bool   `json:"ok"`
+	Output string `json:"output"`
+}
+
+// notSentError is returned by sendDokkuCmd if the command was
+// never sent to the daemon, so it is safe to send it again.
+type notSentError struct {
+	err error
+}
+
+func (e notSentError) Error() string {
+	return e.err.Error()
+}
+
+// sendDokkuCmd runs a command through the dokku daemon and waits for it
+// to finish. An error is only returned if the daemon could not be reached,
+// sent an invalid response or the context was done before the command
+// finished. A failing command is reported in the result.
+func sendDokkuCmd(ctx context.Context, cmd string) (*dokkuResult, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "unix", dokkuSocket)
 	if err != nil {
-		return
+		e := fmt.Sprintf("unable to connect to dokku socket: %v", err)
+		return nil, notSentError{errors.New(e)}
 	}
 	defer c.Close()
 
-	_, err = c.Write([]byte(cmd))
+	// NOTE(happens): Closing the connection makes us stop waiting for
+	// the command, but the daemon might still finish running it
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-stop:
+		}
+	}()
+
+	// NOTE(happens): The daemon reads commands line by line
+	_, err = c.Write([]byte(cmd + "\n"))
 	if err != nil {
-		return
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		e := fmt.Sprintf("unable to write to dokku socket: %v", err)
+		return nil, errors.New(e)
+	}
+
+	var res dokkuResult
+	de := json.NewDecoder(c)
+
+	if err = de.Decode(&res); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		e := fmt.Sprintf("unable to decode dokku response: %v", err)
+		return nil, errors.New(e)
 	}
+
+	return &res, nil
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// NOTE(happens): The storage is opened once for all tests, since jobs
+// can still be read in the background after a test has finished
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		fmt.Printf("could not create storage dir: %v\n", err)
+		os.Exit(1)
+	}
+
+	jobStorage, err = bolt.Open(filepath.Join(dir, "jobs.db"), 0600, nil)
+	if err != nil {
+		fmt.Printf("could not open job storage: %v\n", err)
+		os.Exit(1)
+	}
+
+	hookStorage, err = bolt.Open(filepath.Join(dir, "hooks.db"), 0600, nil)
+	if err != nil {
+		fmt.Printf("could not open hook storage: %v\n", err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+
+	jobStorage.Close()
+	hookStorage.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// resetTestStorage removes all jobs and hooks from the storage.
+func resetTestStorage(t *testing.T) {
+	buckets := map[*bolt.DB][]string{
+		jobStorage:  {jobsBucket},
+		hookStorage: {secretsBucket, enabledBucket},
+	}
+
+	for db, names := range buckets {
+		err := db.Update(func(tx *bolt.Tx) error {
+			existing := [][]byte{}
+			_ = tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+				existing = append(existing, append([]byte{}, name...))
+				return nil
+			})
+
+			for _, name := range existing {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+
+			for _, name := range names {
+				if _, err := tx.CreateBucket([]byte(name)); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("could not reset storage: %v", err)
+		}
+	}
+}
+
+// putTestHook saves a hook for an app in the hook storage.
+func putTestHook(t *testing.T, app string, hook hookData) {
+	err := hookStorage.Update(func(tx *bolt.Tx) error {
+		appBucket, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("app/%s", app)))
+		if err != nil {
+			return err
+		}
+
+		raw, err := json.Marshal(hook)
+		if err != nil {
+			return err
+		}
+
+		return appBucket.Put([]byte(hook.Name), raw)
+	})
+
+	if err != nil {
+		t.Fatalf("could not save hook %s/%s: %v", app, hook.Name, err)
+	}
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// notifyChannel is the kind of target a notifier sends to
+type notifyChannel string
+
+const (
+	// channelWebhook posts a json summary of the job
+	channelWebhook notifyChannel = "webhook"
+	// channelSlack posts a message to a slack compatible
+	// incoming webhook
+	channelSlack notifyChannel = "slack"
+	// channelEmail sends an email using the smtp settings
+	// of the server
+	channelEmail notifyChannel = "email"
+)
+
+// notifyEvent is a reason for sending a notification
+type notifyEvent string
+
+const (
+	eventFailure notifyEvent = "failure"
+	eventSuccess notifyEvent = "success"
+	// eventRecovery is sent for successful jobs if the previous
+	// job of the same hook failed
+	eventRecovery notifyEvent = "recovery"
+	// eventTest is only sent when testing a notifier
+	eventTest notifyEvent = "test"
+)
+
+// notifyOutputLimit is the maximum number of bytes of command
+// output that will be included in a notification
+const notifyOutputLimit = 4 * 1024
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+// notifierData is a rule that sends notifications about the jobs of an
+// app, or of a single hook if Hook is set.
+type notifierData struct {
+	Name    string
+	Channel notifyChannel
+	Target  string
+	Hook    string        `json:",omitempty"`
+	On      []notifyEvent `json:",omitempty"`
+	// Message replaces the default message of the notifier. It
+	// can contain the builtin variables of the job, as well as
+	// #status and #event.
+	Message string `json:",omitempty"`
+}
+
+// notification is the json body sent to generic webhooks
+type notification struct {
+	Event   notifyEvent `json:"event"`
+	App     string      `json:"app"`
+	Hook    string      `json:"hook"`
+	Message string      `json:"message,omitempty"`
+	Job     jobResponse `json:"job"`
+}
+
+// messageVars are the variables that can be used in notification
+// messages besides the builtin variables
+var messageVars = []builtinVar{
+	{"#status", "status of the job"},
+	{"#event", "what the notification is about: failure, success, recovery or test"},
+}
+
+func notifyBucket(app string) []byte {
+	return []byte(fmt.Sprintf("notify/%s", app))
+}
+
+// SetOption sets a single notifier option by the name of its cli flag.
+func (n *notifierData) SetOption(key, value string) error {
+	switch key {
+	case "hook":
+		n.Hook = value
+	case "on":
+		events := []notifyEvent{}
+		for _, s := range strings.Split(value, ",") {
+			ev := notifyEvent(strings.TrimSpace(s))
+			if ev != eventFailure && ev != eventSuccess && ev != eventRecovery {
+				e := fmt.Sprintf("invalid value for on: %s, must be one of failure, success, recovery", s)
+				return errors.New(e)
+			}
+
+			events = append(events, ev)
+		}
+
+		n.On = events
+	case "message":
+		tmpl, err := parseTemplate(value)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range tmpl.Params() {
+			known := isBuiltin(name)
+			for _, v := range messageVars {
+				known = known || v.Name == name
+			}
+
+			if !known {
+				e := fmt.Sprintf("unknown variable %s, see webhooks:info for all variables", name)
+				return errors.New(e)
+			}
+		}
+
+		n.Message = value
+	default:
+		e := fmt.Sprintf("unknown notifier option: %s", key)
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+// Validate checks that the target fits the channel of the notifier.
+func (n notifierData) Validate() error {
+	switch n.Channel {
+	case channelWebhook, channelSlack:
+		u, err := url.Parse(n.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			e := fmt.Sprintf("invalid url: %s", n.Target)
+			return errors.New(e)
+		}
+	case channelEmail:
+		if _, err := mail.ParseAddress(n.Target); err != nil {
+			e := fmt.Sprintf("invalid email address: %s", n.Target)
+			return errors.New(e)
+		}
+	default:
+		e := fmt.Sprintf("unknown channel: %s, must be one of webhook, slack, email", n.Channel)
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+func (n notifierData) events() []notifyEvent {
+	if len(n.On) == 0 {
+		return []notifyEvent{eventFailure, eventRecovery}
+	}
+
+	return n.On
+}
+
+func (n notifierData) wants(ev notifyEvent) bool {
+	for _, e := range n.events() {
+		if e == ev {
+			return true
+		}
+	}
+
+	return false
+}
+
+func addNotifier(app string, n notifierData) error {
+	return hookStorage.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(notifyBucket(app))
+		if err != nil {
+			e := fmt.Sprintf("could not create notifier bucket: %v", err)
+			return errors.New(e)
+		}
+
+		if bucket.Get([]byte(n.Name)) != nil {
+			e := "a notifier with that name already exists"
+			return errors.New(e)
+		}
+
+		ser, err := json.Marshal(n)
+		if err != nil {
+			e := fmt.Sprintf("failed to serialize notifier: %v", err)
+			return errors.New(e)
+		}
+
+		return bucket.Put([]byte(n.Name), ser)
+	})
+}
+
+func removeNotifier(app, name string) error {
+	return hookStorage.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(notifyBucket(app))
+		if bucket == nil || bucket.Get([]byte(name)) == nil {
+			e := fmt.Sprintf("app %s has no notifier named %s", app, name)
+			return errors.New(e)
+		}
+
+		return bucket.Delete([]byte(name))
+	})
+}
+
+// listNotifiers returns all notifiers of an app.
+func listNotifiers(app string) ([]notifierData, error) {
+	result := []notifierData{}
+
+	err := hookStorage.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(notifyBucket(app))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k []byte, v []byte) error {
+			var n notifierData
+			if err := json.Unmarshal(v, &n); err != nil {
+				return nil
+			}
+
+			result = append(result, n)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+// jobEvents returns the events that a finished job causes.
+func jobEvents(job *jobData) []notifyEvent {
+	switch job.Status {
+	case jobFailed, jobTimedOut, jobInterrupted:
+		return []notifyEvent{eventFailure}
+	case jobSucceeded:
+		if previousFailed(job) {
+			return []notifyEvent{eventSuccess, eventRecovery}
+		}
+
+		return []notifyEvent{eventSuccess}
+	}
+
+	// NOTE(happens): Canceled jobs were stopped on purpose,
+	// so nobody has to be told about them
+	return nil
+}
+
+// previousFailed checks whether the last job of the same hook that
+// was created and finished before this one failed.
+// NOTE(happens): This runs for every finished job, so the history is
+// scanned backwards from the job and only until the previous one is found
+func previousFailed(job *jobData) bool {
+	failed := false
+	_ = jobStorage.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(jobsBucket)).Cursor()
+
+		c.Seek(jobKey(job.ID))
+		for k, v := c.Prev(); k != nil; k, v = c.Prev() {
+			var prev jobData
+			if err := json.Unmarshal(v, &prev); err != nil {
+				continue
+			}
+
+			if prev.App != job.App || prev.Hook != job.Hook {
+				continue
+			}
+
+			if !prev.Done() || prev.Status == jobCanceled {
+				continue
+			}
+
+			if prev.Finished != nil && prev.Finished.After(*job.Finished) {
+				continue
+			}
+
+			failed = prev.Status != jobSucceeded
+			return nil
+		}
+
+		return nil
+	})
+
+	return failed
+}
+
+// notifyJob sends notifications for a finished job, without
+// blocking the caller.
+func notifyJob(job *jobData) {
+	summary := newJobResponse(job)
+	go func() {
+		events := jobEvents(job)
+		if len(events) == 0 {
+			return
+		}
+
+		notifiers, err := listNotifiers(job.App)
+		if err != nil {
+			fmt.Printf("unable to load notifiers for %s: %v\n", job.App, err)
+			return
+		}
+
+		for _, n := range notifiers {
+			if len(n.Hook) > 0 && n.Hook != job.Hook {
+				continue
+			}
+
+			// NOTE(happens): Recoveries are also successes, but
+			// only one notification is sent for them
+			for i := len(events) - 1; i >= 0; i-- {
+				if !n.wants(events[i]) {
+					continue
+				}
+
+				if err := n.Send(events[i], summary); err != nil {
+					fmt.Printf("notifier %s of %s failed: %v\n", n.Name, job.App, err)
+				}
+
+				break
+			}
+		}
+	}()
+}
+
+// Send delivers a single notification about a job.
+func (n notifierData) Send(ev notifyEvent, job jobResponse) error {
+	switch n.Channel {
+	case channelWebhook:
+		body, err := json.Marshal(notification{
+			Event:   ev,
+			App:     job.App,
+			Hook:    job.Hook,
+			Message: n.message(ev, job),
+			Job:     job,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return postJSON(n.Target, body)
+	case channelSlack:
+		body, err := json.Marshal(map[string]string{"text": n.message(ev, job)})
+		if err != nil {
+			return err
+		}
+
+		return postJSON(n.Target, body)
+	case channelEmail:
+		return sendEmail(n.Target, ev, job, n.message(ev, job))
+	}
+
+	e := fmt.Sprintf("unknown channel: %s", n.Channel)
+	return errors.New(e)
+}
+
+// notifyTitle returns a single line describing what
+// happened to a job.
+func notifyTitle(ev notifyEvent, job jobResponse) string {
+	name := job.App
+	if len(job.Hook) > 0 {
+		name = fmt.Sprintf("%s/%s", job.App, job.Hook)
+	}
+
+	switch ev {
+	case eventTest:
+		return fmt.Sprintf("[%s] test notification", name)
+	case eventRecovery:
+		return fmt.Sprintf("[%s] job %d recovered", name, job.ID)
+	}
+
+	return fmt.Sprintf("[%s] job %d %s", name, job.ID, job.Status)
+}
+
+// notifyMessage returns a short description of a job
+// for human readable notifications.
+func notifyMessage(ev notifyEvent, job jobResponse) string {
+	msg := notifyTitle(ev, job)
+	if job.Duration > 0 {
+		duration := time.Duration(job.Duration * float64(time.Second))
+		msg = fmt.Sprintf("%s after %s", msg, duration.Round(time.Millisecond))
+	}
+
+	if len(job.Error) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, job.Error)
+	}
+
+	return msg
+}
+
+// message returns the message of the notifier for a job, with
+// all variables substituted.
+func (n notifierData) message(ev notifyEvent, job jobResponse) string {
+	if len(n.Message) == 0 {
+		return notifyMessage(ev, job)
+	}
+
+	tmpl, err := parseTemplate(n.Message)
+	if err != nil {
+		return notifyMessage(ev, job)
+	}
+
+	vars := map[string]string{
+		"#status": string(job.Status),
+		"#event":  string(ev),
+	}
+
+	for k, v := range job.Variables {
+		vars[fmt.Sprintf("#%s", k)] = v
+	}
+
+	return tmpl.Render(vars)
+}
+
+func postJSON(target string, body []byte) error {
+	res, err := notifyClient.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		e := fmt.Sprintf("received status %d", res.StatusCode)
+		return errors.New(e)
+	}
+
+	return nil
+}
+
+func sendEmail(to string, ev notifyEvent, job jobResponse, body string) error {
+	if len(smtpAddr) == 0 {
+		return errors.New("smtp is not configured, set WEBHOOKS_SMTP_ADDR")
+	}
+
+	if len(job.Output) > 0 {
+		body = fmt.Sprintf("%s\n\n%s", body, tail(job.Output, notifyOutputLimit))
+	}
+
+	msg := strings.Join([]string{
+		fmt.Sprintf("From: %s", smtpFrom),
+		fmt.Sprintf("To: %s", to),
+		fmt.Sprintf("Subject: %s", notifyTitle(ev, job)),
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		strings.ReplaceAll(body, "\n", "\r\n"),
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if len(smtpUser) > 0 {
+		host := strings.Split(smtpAddr, ":")[0]
+		auth = smtp.PlainAuth("", smtpUser, smtpPassword, host)
+	}
+
+	return smtp.SendMail(smtpAddr, auth, smtpFrom, []string{to}, []byte(msg))
+}
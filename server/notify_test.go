@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestPreviousFailed(t *testing.T) {
+	at := func(minutes int) *time.Time {
+		finished := time.Date(2019, 5, 1, 10, minutes, 0, 0, time.UTC)
+		return &finished
+	}
+
+	cases := []struct {
+		name    string
+		history []jobData
+		want    bool
+	}{
+		{"first job", nil, false},
+		{"after success", []jobData{
+			{App: "foo", Hook: "a", Status: jobSucceeded, Finished: at(1)},
+		}, false},
+		{"after failure", []jobData{
+			{App: "foo", Hook: "a", Status: jobFailed, Finished: at(1)},
+		}, true},
+		{"after timeout", []jobData{
+			{App: "foo", Hook: "a", Status: jobTimedOut, Finished: at(1)},
+		}, true},
+		{"after interruption", []jobData{
+			{App: "foo", Hook: "a", Status: jobInterrupted, Finished: at(1)},
+		}, true},
+		{"only the last job counts", []jobData{
+			{App: "foo", Hook: "a", Status: jobFailed, Finished: at(1)},
+			{App: "foo", Hook: "a", Status: jobSucceeded, Finished: at(2)},
+		}, false},
+		{"canceled jobs are skipped", []jobData{
+			{App: "foo", Hook: "a", Status: jobFailed, Finished: at(1)},
+			{App: "foo", Hook: "a", Status: jobCanceled, Finished: at(2)},
+		}, true},
+		{"unfinished jobs are skipped", []jobData{
+			{App: "foo", Hook: "a", Status: jobFailed, Finished: at(1)},
+			{App: "foo", Hook: "a", Status: jobRunning},
+		}, true},
+		{"other hooks are skipped", []jobData{
+			{App: "foo", Hook: "a", Status: jobFailed, Finished: at(1)},
+			{App: "foo", Hook: "b", Status: jobSucceeded, Finished: at(2)},
+			{App: "bar", Hook: "a", Status: jobSucceeded, Finished: at(3)},
+		}, true},
+		{"other apps are skipped", []jobData{
+			{App: "bar", Hook: "a", Status: jobFailed, Finished: at(1)},
+		}, false},
+		// NOTE(happens): Jobs that were created earlier but finished
+		// later, like allowed parallel runs, are not previous jobs
+		{"jobs finished later are skipped", []jobData{
+			{App: "foo", Hook: "a", Status: jobFailed, Finished: at(1)},
+			{App: "foo", Hook: "a", Status: jobSucceeded, Finished: at(9)},
+		}, true},
+	}
+
+	for _, c := range cases {
+		resetTestStorage(t)
+
+		job := jobData{App: "foo", Hook: "a", Status: jobSucceeded, Finished: at(5)}
+		history := append(c.history, job)
+
+		err := jobStorage.Update(func(tx *bolt.Tx) error {
+			jobs := tx.Bucket([]byte(jobsBucket))
+			for i := range history {
+				history[i].ID = uint64(i + 1)
+				if err := putJob(jobs, &history[i]); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("could not store jobs: %v", err)
+		}
+
+		job = history[len(history)-1]
+		if got := previousFailed(&job); got != c.want {
+			t.Errorf("%s: previousFailed = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
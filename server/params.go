@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// paramType is the kind of value a parameter accepts
+type paramType string
+
+const (
+	paramString paramType = "string"
+	paramInt    paramType = "int"
+	paramBool   paramType = "bool"
+)
+
+// paramDecl describes the values a parameter of a hook accepts.
+// Parameters that were not declared accept any string.
+type paramDecl struct {
+	Name    string
+	Type    paramType `json:",omitempty"`
+	Enum    []string  `json:",omitempty"`
+	Pattern string    `json:",omitempty"`
+	// Default is used if the parameter is not given, which
+	// makes the parameter optional
+	Default     *string `json:",omitempty"`
+	Description string  `json:",omitempty"`
+}
+
+// paramError lists everything that is wrong with the
+// parameters of a trigger
+type paramError []string
+
+func (e paramError) Error() string {
+	return fmt.Sprintf("invalid parameters:\n- %s", strings.Join(e, "\n- "))
+}
+
+// parseParamDecl reads a parameter declaration of the form
+// name; type=int; enum=a|b; pattern=re; default=a; description=text
+func parseParamDecl(spec string) (paramDecl, error) {
+	parts := strings.Split(spec, ";")
+	name := strings.TrimPrefix(strings.TrimSpace(parts[0]), "#")
+	decl := paramDecl{Name: fmt.Sprintf("#%s", name)}
+	if !validParamName(name) {
+		e := fmt.Sprintf("invalid parameter name: %s", parts[0])
+		return decl, errors.New(e)
+	}
+
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			e := fmt.Sprintf("invalid declaration of %s: %s, expected key=value", decl.Name, part)
+			return decl, errors.New(e)
+		}
+
+		switch kv[0] {
+		case "type":
+			decl.Type = paramType(kv[1])
+			if decl.Type != paramString && decl.Type != paramInt && decl.Type != paramBool {
+				e := fmt.Sprintf("invalid type of %s: %s, must be one of string, int, bool", decl.Name, kv[1])
+				return decl, errors.New(e)
+			}
+		case "enum":
+			decl.Enum = strings.Split(kv[1], "|")
+		case "pattern":
+			if _, err := compilePattern(kv[1]); err != nil {
+				e := fmt.Sprintf("invalid pattern of %s: %v", decl.Name, err)
+				return decl, errors.New(e)
+			}
+
+			decl.Pattern = kv[1]
+		case "default":
+			value := kv[1]
+			decl.Default = &value
+		case "description":
+			decl.Description = kv[1]
+		default:
+			e := fmt.Sprintf("unknown key in declaration of %s: %s", decl.Name, kv[0])
+			return decl, errors.New(e)
+		}
+	}
+
+	if decl.Default != nil {
+		if problems := decl.Check(*decl.Default); len(problems) > 0 {
+			e := fmt.Sprintf("invalid default: %s", strings.Join(problems, ", "))
+			return decl, errors.New(e)
+		}
+	}
+
+	return decl, nil
+}
+
+// compilePattern compiles a parameter pattern, which
+// has to match the whole value.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(fmt.Sprintf("^(?:%s)$", pattern))
+}
+
+func (p paramDecl) paramType() paramType {
+	if len(p.Type) == 0 {
+		return paramString
+	}
+
+	return p.Type
+}
+
+// Check returns everything that is wrong with a value
+// of the parameter.
+func (p paramDecl) Check(value string) []string {
+	problems := []string{}
+
+	switch p.paramType() {
+	case paramInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be an int, got %q", p.Name, value))
+		}
+	case paramBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s must be true or false, got %q", p.Name, value))
+		}
+	}
+
+	if len(p.Enum) > 0 {
+		found := false
+		for _, allowed := range p.Enum {
+			found = found || allowed == value
+		}
+
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s must be one of %s, got %q", p.Name, strings.Join(p.Enum, ", "), value))
+		}
+	}
+
+	if len(p.Pattern) > 0 {
+		re, err := compilePattern(p.Pattern)
+		if err == nil && !re.MatchString(value) {
+			problems = append(problems, fmt.Sprintf("%s must match %s, got %q", p.Name, p.Pattern, value))
+		}
+	}
+
+	return problems
+}
+
+// Allowed returns a short description of the values
+// the parameter accepts besides its type.
+func (p paramDecl) Allowed() string {
+	allowed := []string{}
+	if len(p.Enum) > 0 {
+		allowed = append(allowed, fmt.Sprintf("one of %s", strings.Join(p.Enum, ", ")))
+	}
+
+	if len(p.Pattern) > 0 {
+		allowed = append(allowed, fmt.Sprintf("matching %s", p.Pattern))
+	}
+
+	if len(allowed) == 0 {
+		return "-"
+	}
+
+	return strings.Join(allowed, ", ")
+}
+
+// setParam declares a parameter, replacing an earlier
+// declaration of it.
+func (h *hookData) setParam(spec string) error {
+	decl, err := parseParamDecl(spec)
+	if err != nil {
+		return err
+	}
+
+	for i := range h.Params {
+		if h.Params[i].Name == decl.Name {
+			h.Params[i] = decl
+			return nil
+		}
+	}
+
+	h.Params = append(h.Params, decl)
+	return nil
+}
+
+// removeParam removes the declaration of a parameter.
+func (h *hookData) removeParam(name string) error {
+	name = fmt.Sprintf("#%s", strings.TrimPrefix(name, "#"))
+	for i := range h.Params {
+		if h.Params[i].Name == name {
+			h.Params = append(h.Params[:i], h.Params[i+1:]...)
+			return nil
+		}
+	}
+
+	e := fmt.Sprintf("parameter %s is not declared", name)
+	return errors.New(e)
+}
+
+// param returns the declaration of a parameter, or a plain
+// required string if it was not declared.
+func (h hookData) param(arg string) paramDecl {
+	for _, decl := range h.Params {
+		if decl.Name == arg {
+			return decl
+		}
+	}
+
+	return paramDecl{Name: arg}
+}
+
+// needsParam checks whether a parameter has to be given
+// when the hook is triggered.
+func (h hookData) needsParam(arg string) bool {
+	return !isBuiltin(arg) && h.param(arg).Default == nil
+}
+
+// checkParams makes sure that every declared or mapped
+// parameter is used by a command of the hook.
+func (h hookData) checkParams() error {
+	names := []string{}
+	for _, decl := range h.Params {
+		names = append(names, decl.Name)
+	}
+
+	for _, m := range h.Mappings {
+		names = append(names, m.Param)
+	}
+
+	for _, name := range names {
+		if isBuiltin(name) {
+			e := fmt.Sprintf("%s is set by the server and can't be declared or mapped", name)
+			return errors.New(e)
+		}
+
+		used := false
+		for _, arg := range h.Args {
+			used = used || arg == name
+		}
+
+		if !used {
+			e := fmt.Sprintf("parameter %s is declared or mapped, but not used by any command", name)
+			return errors.New(e)
+		}
+	}
+
+	return nil
+}
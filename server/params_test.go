@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseParamDecl(t *testing.T) {
+	def := func(s string) *string { return &s }
+
+	cases := []struct {
+		spec string
+		want paramDecl
+	}{
+		{"tag", paramDecl{Name: "#tag"}},
+		{"#tag", paramDecl{Name: "#tag"}},
+		{" count ; type=int ", paramDecl{Name: "#count", Type: paramInt}},
+		{"dry; type=bool; default=false", paramDecl{Name: "#dry", Type: paramBool, Default: def("false")}},
+		{"env; enum=staging|production; default=staging", paramDecl{
+			Name:    "#env",
+			Enum:    []string{"staging", "production"},
+			Default: def("staging"),
+		}},
+		{"tag; pattern=v[0-9]+; description=release to deploy", paramDecl{
+			Name:        "#tag",
+			Pattern:     "v[0-9]+",
+			Description: "release to deploy",
+		}},
+		{"ref; default=", paramDecl{Name: "#ref", Default: def("")}},
+		{"url; default=a=b", paramDecl{Name: "#url", Default: def("a=b")}},
+	}
+
+	for _, c := range cases {
+		got, err := parseParamDecl(c.spec)
+		if err != nil {
+			t.Errorf("parseParamDecl(%q) failed: %v", c.spec, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseParamDecl(%q) = %+v, want %+v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestParseParamDeclErrors(t *testing.T) {
+	cases := []string{
+		"",
+		"#",
+		"bad name",
+		"tag; type",
+		"tag; type=float",
+		"tag; color=red",
+		"tag; pattern=(",
+		"env; enum=staging|production; default=dev",
+		"count; type=int; default=many",
+		"dry; type=bool; default=maybe",
+		"tag; pattern=v[0-9]+; default=latest",
+	}
+
+	for _, spec := range cases {
+		if _, err := parseParamDecl(spec); err == nil {
+			t.Errorf("parseParamDecl(%q) should fail", spec)
+		}
+	}
+}
+
+func TestParamDeclCheck(t *testing.T) {
+	cases := []struct {
+		spec     string
+		value    string
+		problems int
+	}{
+		{"tag", "anything", 0},
+		{"count; type=int", "42", 0},
+		{"count; type=int", "-1", 0},
+		{"count; type=int", "4.2", 1},
+		{"dry; type=bool", "true", 0},
+		{"dry; type=bool", "yes", 1},
+		{"env; enum=staging|production", "production", 0},
+		{"env; enum=staging|production", "prod", 1},
+		{"env; enum=staging|production", "", 1},
+		// NOTE(happens): Patterns have to match the whole value
+		{"tag; pattern=v[0-9]+", "v12", 0},
+		{"tag; pattern=v[0-9]+", "v12-rc", 1},
+		{"tag; pattern=v[0-9]+", "xv12", 1},
+		{"tag; pattern=a|b", "ab", 1},
+		{"n; type=int; enum=1|2; pattern=[0-9]", "x", 3},
+	}
+
+	for _, c := range cases {
+		decl, err := parseParamDecl(c.spec)
+		if err != nil {
+			t.Errorf("parseParamDecl(%q) failed: %v", c.spec, err)
+			continue
+		}
+
+		if got := decl.Check(c.value); len(got) != c.problems {
+			t.Errorf("%q.Check(%q) = %q, want %d problem(s)", c.spec, c.value, got, c.problems)
+		}
+	}
+}
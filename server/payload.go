@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// payloadLimit is the maximum size of request bodies that
+// parameters are taken from
+const payloadLimit = 1024 * 1024
+
+var errPayloadTooLarge = fmt.Errorf("request body is larger than %d bytes", payloadLimit)
+
+const (
+	// sourceForm takes a parameter from a form encoded body
+	sourceForm = "form:"
+	// sourceHeader takes a parameter from a request header
+	sourceHeader = "header:"
+)
+
+// paramMapping takes the value of a parameter from the request body
+// or headers instead of the query params. Source is either a json path
+// starting with $, or a form field or header name with a prefix.
+type paramMapping struct {
+	Param  string
+	Source string
+}
+
+// pathElem is a single object key or array index of a json path
+type pathElem struct {
+	key   string
+	index int
+}
+
+// parseMapping reads a mapping of the form name <- source.
+func parseMapping(spec string) (paramMapping, error) {
+	parts := strings.SplitN(spec, "<-", 2)
+	if len(parts) != 2 {
+		e := fmt.Sprintf("invalid mapping: %s, expected <name> <- <source>", spec)
+		return paramMapping{}, errors.New(e)
+	}
+
+	name := strings.TrimPrefix(strings.TrimSpace(parts[0]), "#")
+	if !validParamName(name) {
+		e := fmt.Sprintf("invalid parameter name: %s", parts[0])
+		return paramMapping{}, errors.New(e)
+	}
+
+	m := paramMapping{Param: fmt.Sprintf("#%s", name), Source: strings.TrimSpace(parts[1])}
+	switch {
+	case strings.HasPrefix(m.Source, "$"):
+		if _, err := parseJSONPath(m.Source); err != nil {
+			e := fmt.Sprintf("invalid json path %s: %v", m.Source, err)
+			return m, errors.New(e)
+		}
+	case strings.HasPrefix(m.Source, sourceForm) && len(m.Source) > len(sourceForm):
+	case strings.HasPrefix(m.Source, sourceHeader) && len(m.Source) > len(sourceHeader):
+	default:
+		e := fmt.Sprintf("invalid source: %s, must be a json path like $.key, form:<field> or header:<name>", m.Source)
+		return m, errors.New(e)
+	}
+
+	return m, nil
+}
+
+// parseJSONPath reads a json path like $.key.list[0]["other key"].
+func parseJSONPath(path string) ([]pathElem, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New("must start with $")
+	}
+
+	result := []pathElem{}
+	rest := path[1:]
+
+	for len(rest) > 0 {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+
+			key := rest[1 : end+1]
+			if len(key) == 0 {
+				return nil, errors.New("empty key")
+			}
+
+			if strings.Contains(key, "]") {
+				e := fmt.Sprintf("unexpected ] in key %s, use [\"%s\"] for keys containing it", key, key)
+				return nil, errors.New(e)
+			}
+
+			result = append(result, pathElem{key: key, index: -1})
+			rest = rest[end+1:]
+		case strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest, `"]`)
+			if end < 2 {
+				return nil, errors.New("unterminated key")
+			}
+
+			result = append(result, pathElem{key: rest[2:end], index: -1})
+			rest = rest[end+2:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.New("unterminated index")
+			}
+
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				e := fmt.Sprintf("invalid index: %s", rest[1:end])
+				return nil, errors.New(e)
+			}
+
+			result = append(result, pathElem{index: index})
+			rest = rest[end+1:]
+		default:
+			e := fmt.Sprintf("unexpected %q", rest[0])
+			return nil, errors.New(e)
+		}
+	}
+
+	return result, nil
+}
+
+// lookupJSONPath returns the value at a json path in a document
+// decoded with UseNumber, and whether it exists.
+func lookupJSONPath(doc interface{}, path []pathElem) (interface{}, bool) {
+	current := doc
+	for _, elem := range path {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			if elem.index >= 0 {
+				return nil, false
+			}
+
+			found, ok := v[elem.key]
+			if !ok {
+				return nil, false
+			}
+
+			current = found
+		case []interface{}:
+			if elem.index < 0 || elem.index >= len(v) {
+				return nil, false
+			}
+
+			current = v[elem.index]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, current != nil
+}
+
+// setMapping maps a parameter, replacing an earlier
+// mapping of it.
+func (h *hookData) setMapping(spec string) error {
+	m, err := parseMapping(spec)
+	if err != nil {
+		return err
+	}
+
+	for i := range h.Mappings {
+		if h.Mappings[i].Param == m.Param {
+			h.Mappings[i] = m
+			return nil
+		}
+	}
+
+	h.Mappings = append(h.Mappings, m)
+	return nil
+}
+
+// removeMapping removes the mapping of a parameter, so that
+// it is taken from the query params again.
+func (h *hookData) removeMapping(name string) error {
+	name = fmt.Sprintf("#%s", strings.TrimPrefix(name, "#"))
+	for i := range h.Mappings {
+		if h.Mappings[i].Param == name {
+			h.Mappings = append(h.Mappings[:i], h.Mappings[i+1:]...)
+			return nil
+		}
+	}
+
+	e := fmt.Sprintf("parameter %s is not mapped", name)
+	return errors.New(e)
+}
+
+// source returns where the value of a parameter is
+// taken from in http requests.
+func (h hookData) source(arg string) string {
+	for _, m := range h.Mappings {
+		if m.Param == arg {
+			return m.Source
+		}
+	}
+
+	return "query"
+}
+
+// readPayload reads the body of a request, if it hasn't been
+// used as the secret.
+func readPayload(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, payloadLimit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > payloadLimit {
+		return nil, errPayloadTooLarge
+	}
+
+	return body, nil
+}
+
+// mapParams sets the values of all mapped parameters of a hook from
+// the request. Mapped parameters that are not in the request are
+// removed from values, even if they were passed as query params.
+func mapParams(h hookData, r *http.Request, body []byte, values map[string]string) error {
+	var doc interface{}
+	var form url.Values
+	problems := paramError{}
+
+	for _, m := range h.Mappings {
+		name := strings.TrimPrefix(m.Param, "#")
+		delete(values, name)
+
+		switch {
+		case strings.HasPrefix(m.Source, sourceHeader):
+			if value := r.Header.Get(strings.TrimPrefix(m.Source, sourceHeader)); len(value) > 0 {
+				values[name] = value
+			}
+		case strings.HasPrefix(m.Source, sourceForm):
+			if form == nil {
+				parsed, err := url.ParseQuery(string(body))
+				if err != nil {
+					e := fmt.Sprintf("invalid form body: %v", err)
+					return errors.New(e)
+				}
+
+				form = parsed
+			}
+
+			if field := strings.TrimPrefix(m.Source, sourceForm); len(form[field]) > 0 {
+				values[name] = form.Get(field)
+			}
+		default:
+			// NOTE(happens): An empty body doesn't contain any of the
+			// mapped parameters, so it's left to the parameter checks
+			// to either use the defaults or report them as missing
+			if len(bytes.TrimSpace(body)) == 0 {
+				continue
+			}
+
+			if doc == nil {
+				de := json.NewDecoder(bytes.NewReader(body))
+				de.UseNumber()
+				if err := de.Decode(&doc); err != nil {
+					e := fmt.Sprintf("invalid json body: %v", err)
+					return errors.New(e)
+				}
+			}
+
+			path, _ := parseJSONPath(m.Source)
+			found, ok := lookupJSONPath(doc, path)
+			if !ok {
+				continue
+			}
+
+			switch v := found.(type) {
+			case string:
+				values[name] = v
+			case json.Number:
+				values[name] = v.String()
+			case bool:
+				values[name] = strconv.FormatBool(v)
+			default:
+				problems = append(problems, fmt.Sprintf("%s must be a string, number or bool at %s", m.Param, m.Source))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	cases := []struct {
+		path string
+		want []pathElem
+	}{
+		{"$", []pathElem{}},
+		{"$.tag", []pathElem{{key: "tag", index: -1}}},
+		{"$.push_data.tag", []pathElem{{key: "push_data", index: -1}, {key: "tag", index: -1}}},
+		{"$.commits[0].id", []pathElem{{key: "commits", index: -1}, {index: 0}, {key: "id", index: -1}}},
+		{"$[2][10]", []pathElem{{index: 2}, {index: 10}}},
+		{`$.repository["repo name"]`, []pathElem{{key: "repository", index: -1}, {key: "repo name", index: -1}}},
+		{`$["a.b"].c`, []pathElem{{key: "a.b", index: -1}, {key: "c", index: -1}}},
+		{`$[""]`, []pathElem{{key: "", index: -1}}},
+	}
+
+	for _, c := range cases {
+		got, err := parseJSONPath(c.path)
+		if err != nil {
+			t.Errorf("parseJSONPath(%q) failed: %v", c.path, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseJSONPath(%q) = %+v, want %+v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestParseJSONPathErrors(t *testing.T) {
+	cases := []string{
+		"",
+		"tag",
+		"$tag",
+		"$.",
+		"$..tag",
+		"$.a[",
+		"$.a[x]",
+		"$.a[-1]",
+		"$.a[]",
+		`$["a`,
+		"$.a]",
+	}
+
+	for _, path := range cases {
+		if _, err := parseJSONPath(path); err == nil {
+			t.Errorf("parseJSONPath(%q) should fail", path)
+		}
+	}
+}
+
+func TestParseMapping(t *testing.T) {
+	cases := []struct {
+		spec string
+		want paramMapping
+		ok   bool
+	}{
+		{"tag <- $.push_data.tag", paramMapping{Param: "#tag", Source: "$.push_data.tag"}, true},
+		{"#ref<-header:X-Ref", paramMapping{Param: "#ref", Source: "header:X-Ref"}, true},
+		{"branch <- form:branch", paramMapping{Param: "#branch", Source: "form:branch"}, true},
+		{"tag $.tag", paramMapping{}, false},
+		{"bad name <- $.tag", paramMapping{}, false},
+		{"tag <- tag", paramMapping{}, false},
+		{"tag <- form:", paramMapping{}, false},
+		{"tag <- header:", paramMapping{}, false},
+		{"tag <- $.a[x]", paramMapping{}, false},
+	}
+
+	for _, c := range cases {
+		got, err := parseMapping(c.spec)
+		if !c.ok {
+			if err == nil {
+				t.Errorf("parseMapping(%q) should fail", c.spec)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parseMapping(%q) failed: %v", c.spec, err)
+			continue
+		}
+
+		if got != c.want {
+			t.Errorf("parseMapping(%q) = %+v, want %+v", c.spec, got, c.want)
+		}
+	}
+}
+
+func TestMapParams(t *testing.T) {
+	body := `{
+		"ref": "refs/heads/main",
+		"count": 3,
+		"big": 12345678901234567890,
+		"draft": false,
+		"empty": null,
+		"commits": [{"id": "abc"}, {"id": "def"}],
+		"repository": {"full name": "org/repo"}
+	}`
+
+	cases := []struct {
+		source string
+		value  string
+		found  bool
+	}{
+		{"$.ref", "refs/heads/main", true},
+		{"$.count", "3", true},
+		// NOTE(happens): Numbers are passed exactly as they were sent
+		{"$.big", "12345678901234567890", true},
+		{"$.draft", "false", true},
+		{"$.commits[0].id", "abc", true},
+		{"$.commits[1].id", "def", true},
+		{`$.repository["full name"]`, "org/repo", true},
+		{"$.commits[2].id", "", false},
+		{"$.commits[99]", "", false},
+		{"$.commits.id", "", false},
+		{"$.ref[0]", "", false},
+		{"$.missing", "", false},
+		{"$.empty", "", false},
+	}
+
+	for _, c := range cases {
+		hook := hookData{Mappings: []paramMapping{{Param: "#value", Source: c.source}}}
+		r := httptest.NewRequest("POST", "/app/hook", strings.NewReader(body))
+		values := map[string]string{"value": "from query"}
+
+		if err := mapParams(hook, r, []byte(body), values); err != nil {
+			t.Errorf("mapParams(%q) failed: %v", c.source, err)
+			continue
+		}
+
+		// NOTE(happens): Mapped parameters are never taken from the query
+		value, found := values["value"]
+		if found != c.found || value != c.value {
+			t.Errorf("mapParams(%q) = %q, %v, want %q, %v", c.source, value, found, c.value, c.found)
+		}
+	}
+}
+
+func TestMapParamsErrors(t *testing.T) {
+	cases := []struct {
+		source string
+		body   string
+	}{
+		{"$.commits", `{"commits": [1, 2]}`},
+		{"$.repository", `{"repository": {"name": "repo"}}`},
+		{"$.tag", `not json`},
+		{"form:tag", "tag=%zz"},
+	}
+
+	for _, c := range cases {
+		hook := hookData{Mappings: []paramMapping{{Param: "#value", Source: c.source}}}
+		r := httptest.NewRequest("POST", "/app/hook", strings.NewReader(c.body))
+
+		if err := mapParams(hook, r, []byte(c.body), map[string]string{}); err == nil {
+			t.Errorf("mapParams(%q) with body %q should fail", c.source, c.body)
+		}
+	}
+}
+
+func TestMapParamsEmptyBody(t *testing.T) {
+	hook := hookData{Mappings: []paramMapping{{Param: "#tag", Source: "$.tag"}}}
+
+	for _, body := range []string{"", " \n"} {
+		r := httptest.NewRequest("POST", "/app/hook", strings.NewReader(body))
+		values := map[string]string{"tag": "from query"}
+
+		if err := mapParams(hook, r, []byte(body), values); err != nil {
+			t.Errorf("mapParams with body %q failed: %v", body, err)
+			continue
+		}
+
+		if len(values) != 0 {
+			t.Errorf("mapParams with body %q = %q, want no values", body, values)
+		}
+	}
+}
+
+func TestMapParamsFormAndHeader(t *testing.T) {
+	hook := hookData{Mappings: []paramMapping{
+		{Param: "#branch", Source: "form:branch"},
+		{Param: "#event", Source: "header:X-GitHub-Event"},
+		{Param: "#missing", Source: "header:X-Missing"},
+	}}
+
+	body := "branch=main&other=1"
+	r := httptest.NewRequest("POST", "/app/hook", strings.NewReader(body))
+	r.Header.Set("X-GitHub-Event", "push")
+	values := map[string]string{"missing": "from query"}
+
+	if err := mapParams(hook, r, []byte(body), values); err != nil {
+		t.Fatalf("mapParams failed: %v", err)
+	}
+
+	want := map[string]string{"branch": "main", "event": "push"}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("mapParams = %q, want %q", values, want)
+	}
+}
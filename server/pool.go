@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// queueRetryAfter is sent to clients whose triggers were
+// rejected because the queue is full
+const queueRetryAfter = 30 * time.Second
+
+var errQueueFull = errors.New("server is busy, too many jobs are queued")
+
+// workers limits the number of jobs that run at the same time across
+// all apps. It is nil if the number of workers is unlimited.
+var workers chan struct{}
+
+var poolMu sync.Mutex
+var poolRunning, poolWaiting int
+
+func initPool() {
+	if poolSize > 0 {
+		workers = make(chan struct{}, poolSize)
+	}
+}
+
+// acquireWorker blocks until a worker is free to run a job, or
+// until ctx is canceled.
+func acquireWorker(ctx context.Context) error {
+	poolMu.Lock()
+	poolWaiting++
+	poolMu.Unlock()
+
+	var err error
+	if workers != nil {
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	poolMu.Lock()
+	poolWaiting--
+	if err == nil {
+		poolRunning++
+	}
+	poolMu.Unlock()
+
+	return err
+}
+
+func releaseWorker() {
+	poolMu.Lock()
+	poolRunning--
+	poolMu.Unlock()
+
+	if workers != nil {
+		<-workers
+	}
+}
+
+// poolUsage returns the number of jobs that are running, and the
+// number of jobs that are waiting for a worker.
+func poolUsage() (int, int) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	return poolRunning, poolWaiting
+}
+
+// queuedJobs returns the number of jobs that were accepted but are not
+// running yet, not counting deferred jobs. queuesMu has to be held.
+func queuedJobs() int {
+	_, waiting := poolUsage()
+	for _, q := range queues {
+		waiting += len(q.creating) + len(q.pending)
+	}
+
+	return waiting
+}
+
+// admitJob checks whether there is room in the queue for another
+// job. queuesMu has to be held.
+func admitJob() error {
+	if queueSize > 0 && queuedJobs() >= queueSize {
+		return errQueueFull
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAdmitJob(t *testing.T) {
+	defer func(size int) {
+		queueSize = size
+		queues = make(map[string]*appQueue)
+		poolWaiting = 0
+	}(queueSize)
+
+	cases := []struct {
+		name     string
+		size     int
+		creating int
+		pending  int
+		waiting  int
+		delayed  int
+		ok       bool
+	}{
+		{"empty", 2, 0, 0, 0, 0, true},
+		{"unlimited", 0, 10, 10, 10, 0, true},
+		{"room left", 4, 1, 1, 1, 0, true},
+		{"full", 3, 1, 1, 1, 0, false},
+		{"full of pending jobs", 2, 0, 2, 0, 0, false},
+		{"full of jobs being stored", 2, 2, 0, 0, 0, false},
+		{"full of jobs waiting for a worker", 2, 0, 0, 2, 0, false},
+		// NOTE(happens): Deferred jobs don't take up room until
+		// they are handed off
+		{"deferred jobs", 2, 0, 1, 0, 5, true},
+	}
+
+	for _, c := range cases {
+		queueSize = c.size
+		poolWaiting = c.waiting
+		queues = make(map[string]*appQueue)
+
+		// NOTE(happens): Jobs are spread over two apps, since
+		// the limit is shared by all of them
+		for i := 0; i < c.creating+c.pending+c.delayed; i++ {
+			q := getQueue(fmt.Sprintf("app%d", i%2))
+			job := &jobData{ID: uint64(i + 1), Hook: fmt.Sprintf("hook%d", i)}
+
+			switch {
+			case i < c.creating:
+				q.creating = append(q.creating, &newJob{job: job})
+			case i < c.creating+c.pending:
+				q.pending = append(q.pending, job)
+			default:
+				q.delayed[job.Hook] = job
+			}
+		}
+
+		err := admitJob()
+		if c.ok && err != nil {
+			t.Errorf("%s: admitJob failed: %v", c.name, err)
+		}
+
+		if !c.ok && err != errQueueFull {
+			t.Errorf("%s: admitJob should reject the job, got %v", c.name, err)
+		}
+	}
+}
+
+func TestAcquireWorker(t *testing.T) {
+	defer func(w chan struct{}) { workers = w }(workers)
+	workers = make(chan struct{}, 1)
+
+	if err := acquireWorker(context.Background()); err != nil {
+		t.Fatalf("acquireWorker failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := acquireWorker(ctx); err == nil {
+		t.Fatalf("acquireWorker should wait for the busy worker")
+	}
+
+	if running, waiting := poolUsage(); running != 1 || waiting != 0 {
+		t.Errorf("poolUsage = %d, %d, want 1, 0", running, waiting)
+	}
+
+	releaseWorker()
+	if err := acquireWorker(context.Background()); err != nil {
+		t.Fatalf("acquireWorker failed after the worker was released: %v", err)
+	}
+
+	releaseWorker()
+	if running, waiting := poolUsage(); running != 0 || waiting != 0 {
+		t.Errorf("poolUsage = %d, %d, want 0, 0", running, waiting)
+	}
+}
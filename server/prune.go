@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// pruneResult reports how much was removed from the job history
+type pruneResult struct {
+	Deleted   int
+	Truncated int
+	Bytes     int
+}
+
+// truncateOutput shortens output that is larger than the configured
+// maximum, keeping its beginning and end. The result never exceeds
+// the maximum, so already truncated output is left alone.
+func truncateOutput(output string) string {
+	if maxOutputSize == 0 || len(output) <= maxOutputSize {
+		return output
+	}
+
+	// NOTE(happens): The marker is sized for the full length of the
+	// output, which is at least as long as the actual count
+	marker := "\n[... %d bytes truncated ...]\n"
+	keep := maxOutputSize - len(fmt.Sprintf(marker, len(output)))
+	if keep <= 0 {
+		return output[:maxOutputSize]
+	}
+
+	head := keep / 2
+	tail := keep - head
+	removed := len(output) - keep
+	return output[:head] + fmt.Sprintf(marker, removed) + output[len(output)-tail:]
+}
+
+// pruneJobs enforces the retention limits on the job history. If app is
+// empty, jobs of all apps are pruned. Unfinished jobs are never removed.
+func pruneJobs(app string) (pruneResult, error) {
+	var result pruneResult
+	cutoff := time.Now().Add(-maxJobAge)
+
+	err := jobStorage.Update(func(tx *bolt.Tx) error {
+		jobs := tx.Bucket([]byte(jobsBucket))
+		perHook := make(map[string]int)
+		deleted := [][]byte{}
+		truncated := []*jobData{}
+
+		c := jobs.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			var job jobData
+			if err := json.Unmarshal(v, &job); err != nil {
+				continue
+			}
+
+			if (len(app) > 0 && job.App != app) || !job.Done() {
+				continue
+			}
+
+			hookKey := fmt.Sprintf("%s/%s", job.App, job.Hook)
+			perHook[hookKey]++
+
+			tooMany := maxJobsPerHook > 0 && perHook[hookKey] > maxJobsPerHook
+			tooOld := maxJobAge > 0 && job.Created.Before(cutoff)
+			if tooMany || tooOld {
+				// NOTE(happens): Deleting while iterating
+				// makes the cursor skip entries
+				key := make([]byte, len(k))
+				copy(key, k)
+				deleted = append(deleted, key)
+				result.Bytes += len(v)
+				continue
+			}
+
+			if truncateJob(&job) {
+				truncated = append(truncated, &job)
+				result.Bytes += len(v)
+			}
+		}
+
+		for _, key := range deleted {
+			if err := jobs.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		for _, job := range truncated {
+			ser, err := json.Marshal(job)
+			if err != nil {
+				return err
+			}
+
+			if err := jobs.Put(jobKey(job.ID), ser); err != nil {
+				return err
+			}
+
+			result.Bytes -= len(ser)
+		}
+
+		result.Deleted = len(deleted)
+		result.Truncated = len(truncated)
+		return nil
+	})
+
+	return result, err
+}
+
+// truncateJob applies the output size limit to a job, and
+// reports whether anything was changed.
+func truncateJob(job *jobData) bool {
+	changed := false
+
+	if output := truncateOutput(job.Output); output != job.Output {
+		job.Output = output
+		changed = true
+	}
+
+	for i := range job.Attempts {
+		attempt := &job.Attempts[i]
+		if output := truncateOutput(attempt.Output); output != attempt.Output {
+			attempt.Output = output
+			changed = true
+		}
+	}
+
+	for i := range job.Steps {
+		step := &job.Steps[i]
+		if output := truncateOutput(step.Output); output != step.Output {
+			step.Output = output
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// pruneJobsPeriodically prunes the job history of all apps
+// in the configured interval.
+func pruneJobsPeriodically() {
+	if pruneInterval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result, err := pruneJobs("")
+		if err != nil {
+			fmt.Printf("unable to prune jobs: %v\n", err)
+			continue
+		}
+
+		fmt.Printf(
+			"pruned %d jobs and truncated %d, freed %d bytes\n",
+			result.Deleted, result.Truncated, result.Bytes,
+		)
+	}
+}
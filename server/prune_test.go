@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestTruncateOutput(t *testing.T) {
+	defer func(size int) { maxOutputSize = size }(maxOutputSize)
+
+	long := strings.Repeat("a", 100) + strings.Repeat("b", 100)
+
+	cases := []struct {
+		max    int
+		output string
+		want   string
+	}{
+		{0, long, long},
+		{64, "short", "short"},
+		{200, long, long},
+		{64, long, strings.Repeat("a", 16) + "\n[... 167 bytes truncated ...]\n" + strings.Repeat("b", 17)},
+		// NOTE(happens): If the marker doesn't fit, the
+		// output is cut off
+		{16, long, strings.Repeat("a", 16)},
+	}
+
+	for _, c := range cases {
+		maxOutputSize = c.max
+		got := truncateOutput(c.output)
+		if got != c.want {
+			t.Errorf("truncateOutput(%d bytes) with max %d = %q, want %q", len(c.output), c.max, got, c.want)
+		}
+
+		if c.max > 0 && len(got) > c.max {
+			t.Errorf("truncateOutput(%d bytes) with max %d returned %d bytes", len(c.output), c.max, len(got))
+		}
+
+		if again := truncateOutput(got); again != got {
+			t.Errorf("truncateOutput with max %d changed truncated output to %q", c.max, again)
+		}
+	}
+}
+
+func TestPruneJobs(t *testing.T) {
+	defer func(perHook int, age time.Duration, size int) {
+		maxJobsPerHook, maxJobAge, maxOutputSize = perHook, age, size
+	}(maxJobsPerHook, maxJobAge, maxOutputSize)
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	long := strings.Repeat("a", 200)
+
+	stored := []jobData{
+		{ID: 1, App: "foo", Hook: "a", Status: jobSucceeded, Created: now},
+		{ID: 2, App: "foo", Hook: "a", Status: jobFailed, Created: now},
+		{ID: 3, App: "foo", Hook: "a", Status: jobSucceeded, Created: now},
+		{ID: 4, App: "foo", Hook: "b", Status: jobSucceeded, Created: old},
+		{ID: 5, App: "foo", Hook: "b", Status: jobRunning, Created: old},
+		{ID: 6, App: "foo", Hook: "b", Status: jobFailed, Created: now, Output: long},
+		{ID: 7, App: "bar", Hook: "a", Status: jobSucceeded, Created: old, Output: long},
+	}
+
+	cases := []struct {
+		name      string
+		app       string
+		perHook   int
+		age       time.Duration
+		size      int
+		remaining []uint64
+		truncated int
+	}{
+		{"no limits", "", 0, 0, 0, []uint64{1, 2, 3, 4, 5, 6, 7}, 0},
+		{"per hook", "", 2, 0, 0, []uint64{2, 3, 4, 5, 6, 7}, 0},
+		// NOTE(happens): Unfinished jobs are neither removed
+		// nor counted
+		{"per hook with running job", "", 1, 0, 0, []uint64{3, 5, 6, 7}, 0},
+		{"per hook for app", "foo", 1, 0, 0, []uint64{3, 5, 6, 7}, 0},
+		{"age", "", 0, time.Hour, 0, []uint64{1, 2, 3, 5, 6}, 0},
+		{"age for app", "foo", 0, time.Hour, 0, []uint64{1, 2, 3, 5, 6, 7}, 0},
+		{"output", "", 0, 0, 64, []uint64{1, 2, 3, 4, 5, 6, 7}, 2},
+		{"everything", "", 1, time.Hour, 64, []uint64{3, 5, 6}, 1},
+	}
+
+	for _, c := range cases {
+		resetTestStorage(t)
+		maxJobsPerHook, maxJobAge, maxOutputSize = c.perHook, c.age, c.size
+
+		err := jobStorage.Update(func(tx *bolt.Tx) error {
+			jobs := tx.Bucket([]byte(jobsBucket))
+			for i := range stored {
+				if err := putJob(jobs, &stored[i]); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("could not store jobs: %v", err)
+		}
+
+		result, err := pruneJobs(c.app)
+		if err != nil {
+			t.Errorf("%s: pruneJobs failed: %v", c.name, err)
+			continue
+		}
+
+		remaining := []uint64{}
+		_ = jobStorage.View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(jobsBucket)).ForEach(func(k []byte, v []byte) error {
+				var job jobData
+				if err := json.Unmarshal(v, &job); err != nil {
+					return err
+				}
+
+				if c.size > 0 && len(job.Output) > c.size {
+					t.Errorf("%s: output of job %d was not truncated", c.name, job.ID)
+				}
+
+				remaining = append(remaining, job.ID)
+				return nil
+			})
+		})
+
+		if !equalIDs(remaining, c.remaining) {
+			t.Errorf("%s: remaining = %v, want %v", c.name, remaining, c.remaining)
+		}
+
+		if result.Deleted != len(stored)-len(c.remaining) || result.Truncated != c.truncated {
+			t.Errorf("%s: deleted %d and truncated %d, want %d and %d", c.name,
+				result.Deleted, result.Truncated, len(stored)-len(c.remaining), c.truncated)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// concurrencyPolicy decides how a new job for a hook is treated when
+// other jobs for the same app are already queued or running.
+type concurrencyPolicy string
+
+const (
+	// concurrencyAllow runs the job right away, in parallel
+	// to everything else.
+	concurrencyAllow concurrencyPolicy = "allow"
+	// concurrencyQueue queues the job behind all other jobs of
+	// the app. This is the default.
+	concurrencyQueue concurrencyPolicy = "queue"
+	// concurrencySkip drops the job if another job for the same
+	// hook is already queued or running.
+	concurrencySkip concurrencyPolicy = "skip"
+	// concurrencyReplace cancels all queued jobs for the same hook
+	// and queues the new one in their place.
+	concurrencyReplace concurrencyPolicy = "replace"
+)
+
+func (p concurrencyPolicy) Valid() bool {
+	switch p {
+	case concurrencyAllow, concurrencyQueue, concurrencySkip, concurrencyReplace:
+		return true
+	}
+
+	return false
+}
+
+// cooldownMode decides what happens to triggers that arrive while
+// a hook is cooling down after a successful job.
+type cooldownMode string
+
+const (
+	// cooldownReject rejects the trigger. This is the default.
+	cooldownReject cooldownMode = "reject"
+	// cooldownDefer runs the job once the cooldown is over.
+	cooldownDefer cooldownMode = "defer"
+)
+
+// triggerOutcome tells the caller what happened to a trigger
+type triggerOutcome string
+
+const (
+	// outcomeExecuted means a new job was handed off to be run
+	outcomeExecuted triggerOutcome = "executed"
+	// outcomeDeferred means a new job was created that will
+	// be run once its debounce or cooldown window is over
+	outcomeDeferred triggerOutcome = "deferred"
+	// outcomeCoalesced means the trigger was merged into a
+	// deferred job that was already waiting
+	outcomeCoalesced triggerOutcome = "coalesced"
+)
+
+var errJobSkipped = errors.New("skipped, a job for this hook is already queued or running")
+
+// cooldownError is returned for triggers that were
+// rejected because the hook is cooling down.
+type cooldownError struct {
+	remaining time.Duration
+}
+
+func (e cooldownError) Error() string {
+	return fmt.Sprintf("hook is cooling down, try again in %s", e.remaining.Round(time.Second))
+}
+
+// appQueue serializes the jobs of a single app. All access has to be
+// guarded by queuesMu, which is never held while jobs are stored.
+type appQueue struct {
+	// busy is set while a job from the queue is being run
+	busy bool
+	// creating contains the jobs that were accepted and are being
+	// stored, in the order they were accepted
+	creating []*newJob
+	pending  []*jobData
+	// active contains all running jobs for the app, including
+	// the ones that were allowed to run in parallel
+	active map[uint64]activeJob
+	// delayed contains the deferred job for each hook, which
+	// will be queued once its timer fires
+	delayed map[string]*jobData
+}
+
+// newJob is a job that is handed off once it has been stored
+type newJob struct {
+	job    *jobData
+	policy concurrencyPolicy
+	stored bool
+}
+
+type activeJob struct {
+	job    *jobData
+	cancel context.CancelFunc
+	// working is set while the job has a worker
+	working bool
+}
+
+// abortedJob is a job that was removed from a queue, and is
+// aborted once queuesMu has been released.
+type abortedJob struct {
+	job    *jobData
+	reason string
+}
+
+func abortJobs(aborted []abortedJob) {
+	for _, a := range aborted {
+		abortJob(a.job, jobCanceled, a.reason)
+	}
+}
+
+var queuesMu sync.Mutex
+var queues = make(map[string]*appQueue)
+
+func getQueue(app string) *appQueue {
+	q, ok := queues[app]
+	if !ok {
+		q = &appQueue{
+			active:  make(map[uint64]activeJob),
+			delayed: make(map[string]*jobData),
+		}
+		queues[app] = q
+	}
+
+	return q
+}
+
+// hasHook checks whether a job for the hook is queued or running.
+func (q *appQueue) hasHook(hook string) bool {
+	for _, n := range q.creating {
+		if n.job.Hook == hook {
+			return true
+		}
+	}
+
+	for _, job := range q.pending {
+		if job.Hook == hook {
+			return true
+		}
+	}
+
+	for _, a := range q.active {
+		if a.job.Hook == hook {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replacePending removes all queued jobs for the hook, and
+// returns them so that they can be canceled.
+func (q *appQueue) replacePending(hook string, by uint64) []abortedJob {
+	remaining := []*jobData{}
+	replaced := []abortedJob{}
+	for _, job := range q.pending {
+		if job.Hook != hook {
+			remaining = append(remaining, job)
+			continue
+		}
+
+		reason := fmt.Sprintf("replaced by job %d", by)
+		replaced = append(replaced, abortedJob{job, reason})
+	}
+
+	q.pending = remaining
+	return replaced
+}
+
+// submitJob records a new job and either hands it off according to the
+// concurrency policy of its hook, or defers it if the hook is debounced
+// or cooling down.
+func submitJob(job *jobData, hook hookData) (*jobData, triggerOutcome, error) {
+	policy := hook.concurrency()
+
+	queuesMu.Lock()
+	q := getQueue(job.App)
+	accepted, outcome, snapshot, err := q.accept(job, hook)
+	queuesMu.Unlock()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = snapshot.store()
+
+	switch outcome {
+	case outcomeExecuted:
+		// NOTE(happens): Jobs are handed off in the order they were
+		// accepted, even if they were stored in a different order
+		queuesMu.Lock()
+		aborted := q.handOffStored(job, err == nil)
+		queuesMu.Unlock()
+
+		abortJobs(aborted)
+
+	case outcomeDeferred:
+		if err != nil {
+			queuesMu.Lock()
+			if q.delayed[job.Hook] == job {
+				delete(q.delayed, job.Hook)
+			}
+			queuesMu.Unlock()
+
+			// NOTE(happens): Other triggers might have been merged
+			// into the job already, so it has to be aborted properly
+			abortJob(job, jobCanceled, fmt.Sprintf("could not be stored: %v", err))
+			break
+		}
+
+		delay := time.Until(*job.NotBefore)
+		time.AfterFunc(delay, func() { releaseJob(q, job, policy) })
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return accepted, outcome, nil
+}
+
+// accept decides what happens to a new job, and returns the job the
+// trigger ended up in along with a snapshot of it that still has to
+// be stored. queuesMu has to be held.
+func (q *appQueue) accept(job *jobData, hook hookData) (*jobData, triggerOutcome, jobSnapshot, error) {
+	// NOTE(happens): Retries have to run exactly the steps of the
+	// failed job, so they are never merged with or turned into a
+	// deferred job
+	retry := job.RetryOf > 0
+
+	if delayed, ok := q.delayed[job.Hook]; ok {
+		if retry {
+			e := fmt.Sprintf("job %d is deferred for this hook, retry once it has run or cancel it", delayed.ID)
+			return nil, "", jobSnapshot{}, errors.New(e)
+		}
+
+		// NOTE(happens): The deferred job will run with the
+		// parameters of the latest trigger
+		delayed.Steps = job.Steps
+		delayed.RemoteAddr = job.RemoteAddr
+		delayed.DeliveryID = job.DeliveryID
+		if job.render != nil {
+			steps, err := job.render(delayed.Variables())
+			if err != nil {
+				return nil, "", jobSnapshot{}, err
+			}
+
+			delayed.Steps = steps
+		}
+
+		delayed.Callbacks = append(delayed.Callbacks, job.Callbacks...)
+		if len(job.secret) > 0 {
+			delayed.secret = job.secret
+		}
+
+		snapshot, err := delayed.snapshot()
+		if err != nil {
+			return nil, "", jobSnapshot{}, err
+		}
+
+		return delayed, outcomeCoalesced, snapshot, nil
+	}
+
+	var delay time.Duration
+	if hook.Cooldown > 0 && hook.LastSuccess != nil {
+		cooldown := time.Duration(hook.Cooldown) * time.Second
+		remaining := time.Until(time.Unix(*hook.LastSuccess, 0).Add(cooldown))
+
+		if remaining > 0 && (hook.cooldownMode() == cooldownReject || retry) {
+			return nil, "", jobSnapshot{}, cooldownError{remaining}
+		}
+
+		if remaining > 0 {
+			delay = remaining
+		}
+	}
+
+	debounce := time.Duration(hook.Debounce) * time.Second
+	if debounce > delay && !retry {
+		delay = debounce
+	}
+
+	policy := hook.concurrency()
+	if delay == 0 && policy == concurrencySkip && q.hasHook(job.Hook) {
+		return nil, "", jobSnapshot{}, errJobSkipped
+	}
+
+	if err := admitJob(); err != nil {
+		return nil, "", jobSnapshot{}, err
+	}
+
+	// NOTE(happens): Retries are assigned their id before they
+	// are submitted, so that the failed job can be claimed first
+	if job.ID == 0 {
+		if err := assignJobID(job); err != nil {
+			return nil, "", jobSnapshot{}, err
+		}
+	}
+
+	outcome := outcomeExecuted
+	if delay > 0 {
+		notBefore := time.Now().Add(delay)
+		job.NotBefore = &notBefore
+		outcome = outcomeDeferred
+	}
+
+	snapshot, err := job.snapshot()
+	if err != nil {
+		return nil, "", jobSnapshot{}, err
+	}
+
+	// NOTE(happens): Deferred jobs are known right away, so that
+	// other triggers are merged into them while they are stored
+	if outcome == outcomeDeferred {
+		q.delayed[job.Hook] = job
+	} else {
+		q.creating = append(q.creating, &newJob{job: job, policy: policy})
+	}
+
+	return job, outcome, snapshot, nil
+}
+
+// handOffStored records whether a new job was stored, and hands off
+// all new jobs at the front that were stored by now. Jobs that could
+// not be stored are dropped. queuesMu has to be held.
+func (q *appQueue) handOffStored(job *jobData, stored bool) []abortedJob {
+	for i, n := range q.creating {
+		if n.job != job {
+			continue
+		}
+
+		if !stored {
+			q.creating = append(q.creating[:i], q.creating[i+1:]...)
+		} else {
+			n.stored = true
+		}
+
+		break
+	}
+
+	aborted := []abortedJob{}
+	for len(q.creating) > 0 && q.creating[0].stored {
+		n := q.creating[0]
+		q.creating = q.creating[1:]
+		aborted = append(aborted, q.dispatch(n.job, n.policy)...)
+	}
+
+	return aborted
+}
+
+// resumeJob hands off a job that was recorded before the server
+// was restarted, keeping its deferral if it has one.
+func resumeJob(job *jobData, policy concurrencyPolicy) {
+	queuesMu.Lock()
+	q := getQueue(job.App)
+	if job.NotBefore != nil && time.Now().Before(*job.NotBefore) {
+		q.delayed[job.Hook] = job
+		delay := time.Until(*job.NotBefore)
+		time.AfterFunc(delay, func() { releaseJob(q, job, policy) })
+		queuesMu.Unlock()
+		return
+	}
+
+	aborted := q.dispatch(job, policy)
+	queuesMu.Unlock()
+
+	abortJobs(aborted)
+}
+
+// releaseJob hands off a deferred job once its timer has fired.
+func releaseJob(q *appQueue, job *jobData, policy concurrencyPolicy) {
+	queuesMu.Lock()
+	if q.delayed[job.Hook] != job {
+		queuesMu.Unlock()
+		return
+	}
+
+	delete(q.delayed, job.Hook)
+
+	var aborted []abortedJob
+	if policy == concurrencySkip && q.hasHook(job.Hook) {
+		aborted = []abortedJob{{job, errJobSkipped.Error()}}
+	} else {
+		aborted = q.dispatch(job, policy)
+	}
+	queuesMu.Unlock()
+
+	abortJobs(aborted)
+}
+
+// dispatch hands off a recorded job according to the concurrency
+// policy, and returns the jobs it replaced. queuesMu has to be held.
+func (q *appQueue) dispatch(job *jobData, policy concurrencyPolicy) []abortedJob {
+	var replaced []abortedJob
+	switch policy {
+	case concurrencyAllow:
+		ctx := q.activate(job)
+		go runActive(ctx, q, job)
+		return nil
+
+	case concurrencyReplace:
+		replaced = q.replacePending(job.Hook, job.ID)
+	}
+
+	q.pending = append(q.pending, job)
+	if !q.busy {
+		q.busy = true
+		go runQueue(q)
+	}
+
+	return replaced
+}
+
+// runQueue runs the jobs of an app one after another, until
+// the queue is empty.
+func runQueue(q *appQueue) {
+	for {
+		queuesMu.Lock()
+		if len(q.pending) == 0 {
+			q.busy = false
+			queuesMu.Unlock()
+			return
+		}
+
+		job := q.pending[0]
+		q.pending = q.pending[1:]
+		ctx := q.activate(job)
+		queuesMu.Unlock()
+
+		runActive(ctx, q, job)
+	}
+}
+
+// activate marks a job as running and returns the context it should
+// be run with. queuesMu has to be held.
+func (q *appQueue) activate(job *jobData) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.active[job.ID] = activeJob{job: job, cancel: cancel}
+	return ctx
+}
+
+// runActive runs a job that has already been marked as active.
+// Jobs wait for a free worker before they are run, and count as queued
+// until then.
+func runActive(ctx context.Context, q *appQueue, job *jobData) {
+	if err := q.acquireWorker(ctx, job); err != nil {
+		abortJob(job, jobCanceled, "canceled before it was run")
+	} else {
+		runJob(ctx, q, job)
+	}
+
+	queuesMu.Lock()
+	a := q.active[job.ID]
+	a.cancel()
+	delete(q.active, job.ID)
+	queuesMu.Unlock()
+
+	if a.working {
+		releaseWorker()
+	}
+}
+
+// acquireWorker waits for a worker for an active job, and marks
+// the job as working once it got one.
+func (q *appQueue) acquireWorker(ctx context.Context, job *jobData) error {
+	if err := acquireWorker(ctx); err != nil {
+		return err
+	}
+
+	queuesMu.Lock()
+	a := q.active[job.ID]
+	a.working = true
+	q.active[job.ID] = a
+	queuesMu.Unlock()
+
+	return nil
+}
+
+// releaseWorker gives up the worker of an active job, so that other
+// jobs can run while it waits for its next attempt.
+func (q *appQueue) releaseWorker(job *jobData) {
+	queuesMu.Lock()
+	a := q.active[job.ID]
+	a.working = false
+	q.active[job.ID] = a
+	queuesMu.Unlock()
+
+	releaseWorker()
+}
+
+// cancelJob aborts a job of an app that is deferred,
+// queued or running.
+func cancelJob(app string, id uint64) error {
+	queuesMu.Lock()
+	job, running := cancelQueued(app, id)
+	queuesMu.Unlock()
+
+	if job == nil {
+		e := fmt.Sprintf("job %d is not queued or running for %s", id, app)
+		return errors.New(e)
+	}
+
+	// NOTE(happens): Running jobs record the reason themselves
+	// once they have stopped
+	if !running {
+		abortJob(job, jobCanceled, "canceled before it was run")
+	}
+
+	return nil
+}
+
+// cancelQueued cancels a running job or removes a deferred or queued
+// job from its queue, and returns it along with whether it was
+// running. queuesMu has to be held.
+func cancelQueued(app string, id uint64) (*jobData, bool) {
+	q, ok := queues[app]
+	if !ok {
+		return nil, false
+	}
+
+	if a, ok := q.active[id]; ok {
+		a.cancel()
+		return a.job, true
+	}
+
+	for i, job := range q.pending {
+		if job.ID != id {
+			continue
+		}
+
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		return job, false
+	}
+
+	for hook, job := range q.delayed {
+		if job.ID != id {
+			continue
+		}
+
+		delete(q.delayed, hook)
+		return job, false
+	}
+
+	return nil, false
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestQueue returns a busy queue with pending jobs for the given
+// hooks, so that dispatched jobs are only queued and not run.
+func newTestQueue(hooks ...string) *appQueue {
+	q := &appQueue{
+		busy:    true,
+		active:  make(map[uint64]activeJob),
+		delayed: make(map[string]*jobData),
+	}
+
+	for i, hook := range hooks {
+		q.pending = append(q.pending, &jobData{ID: uint64(i + 1), Hook: hook})
+	}
+
+	return q
+}
+
+func TestConcurrencyPolicies(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   concurrencyPolicy
+		queued   []string
+		skipped  bool
+		pending  []uint64
+		replaced []uint64
+	}{
+		{"queue", concurrencyQueue, []string{"foo", "bar"}, false, []uint64{1, 2, 10}, nil},
+		{"default", "", []string{"foo"}, false, []uint64{1, 10}, nil},
+		{"skip", concurrencySkip, []string{"bar"}, false, []uint64{1, 10}, nil},
+		{"skip with queued job", concurrencySkip, []string{"bar", "foo"}, true, []uint64{1, 2}, nil},
+		{"replace", concurrencyReplace, []string{"bar"}, false, []uint64{1, 10}, nil},
+		{"replace with queued jobs", concurrencyReplace, []string{"foo", "bar", "foo"}, false, []uint64{2, 10}, []uint64{1, 3}},
+	}
+
+	for _, c := range cases {
+		q := newTestQueue(c.queued...)
+		job := &jobData{ID: 10, Hook: "foo"}
+
+		_, outcome, _, err := q.accept(job, hookData{Concurrency: c.policy})
+		if c.skipped {
+			if err != errJobSkipped {
+				t.Errorf("%s: accept should skip the job, got %v", c.name, err)
+			}
+		} else if err != nil || outcome != outcomeExecuted {
+			t.Errorf("%s: accept = %s, %v, want %s", c.name, outcome, err, outcomeExecuted)
+			continue
+		}
+
+		var aborted []abortedJob
+		if !c.skipped {
+			aborted = q.handOffStored(job, true)
+		}
+
+		pending := []uint64{}
+		for _, job := range q.pending {
+			pending = append(pending, job.ID)
+		}
+
+		if !equalIDs(pending, c.pending) {
+			t.Errorf("%s: pending = %v, want %v", c.name, pending, c.pending)
+		}
+
+		replaced := []uint64{}
+		for _, a := range aborted {
+			replaced = append(replaced, a.job.ID)
+		}
+
+		if !equalIDs(replaced, c.replaced) {
+			t.Errorf("%s: replaced = %v, want %v", c.name, replaced, c.replaced)
+		}
+	}
+}
+
+func TestSkipWhileStoring(t *testing.T) {
+	q := newTestQueue()
+	hook := hookData{Concurrency: concurrencySkip}
+
+	first := &jobData{ID: 1, Hook: "foo"}
+	if _, _, _, err := q.accept(first, hook); err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+
+	// NOTE(happens): The first job hasn't been stored yet, but
+	// still counts as queued
+	second := &jobData{ID: 2, Hook: "foo"}
+	if _, _, _, err := q.accept(second, hook); err != errJobSkipped {
+		t.Errorf("accept should skip the job while another one is stored, got %v", err)
+	}
+
+	q.handOffStored(first, false)
+	if _, _, _, err := q.accept(second, hook); err != nil {
+		t.Errorf("accept failed after the first job could not be stored: %v", err)
+	}
+}
+
+func TestHandOffStoredOrder(t *testing.T) {
+	q := newTestQueue()
+	jobs := []*jobData{{ID: 1, Hook: "foo"}, {ID: 2, Hook: "foo"}, {ID: 3, Hook: "foo"}}
+	for _, job := range jobs {
+		if _, _, _, err := q.accept(job, hookData{}); err != nil {
+			t.Fatalf("accept failed: %v", err)
+		}
+	}
+
+	cases := []struct {
+		job     *jobData
+		stored  bool
+		pending []uint64
+	}{
+		{jobs[2], true, []uint64{}},
+		{jobs[1], false, []uint64{}},
+		{jobs[0], true, []uint64{1, 3}},
+	}
+
+	for _, c := range cases {
+		q.handOffStored(c.job, c.stored)
+
+		pending := []uint64{}
+		for _, job := range q.pending {
+			pending = append(pending, job.ID)
+		}
+
+		if !equalIDs(pending, c.pending) {
+			t.Errorf("after job %d: pending = %v, want %v", c.job.ID, pending, c.pending)
+		}
+	}
+}
+
+func TestDebounceAndCooldown(t *testing.T) {
+	justNow := time.Now().Unix()
+	longAgo := time.Now().Add(-time.Hour).Unix()
+
+	cases := []struct {
+		name     string
+		hook     hookData
+		delayed  bool
+		want     triggerOutcome
+		cooldown bool
+		delay    time.Duration
+	}{
+		{"no delay", hookData{}, false, outcomeExecuted, false, 0},
+		{"debounce", hookData{Debounce: 10}, false, outcomeDeferred, false, 10 * time.Second},
+		{"debounce with deferred job", hookData{Debounce: 10}, true, outcomeCoalesced, false, 0},
+		{"cooldown", hookData{Cooldown: 60, LastSuccess: &justNow}, false, "", true, 0},
+		{"cooldown over", hookData{Cooldown: 60, LastSuccess: &longAgo}, false, outcomeExecuted, false, 0},
+		{"cooldown without success", hookData{Cooldown: 60}, false, outcomeExecuted, false, 0},
+		{"deferring cooldown", hookData{Cooldown: 60, CooldownMode: cooldownDefer, LastSuccess: &justNow}, false, outcomeDeferred, false, 60 * time.Second},
+		{"deferring cooldown with deferred job", hookData{Cooldown: 60, CooldownMode: cooldownDefer, LastSuccess: &justNow}, true, outcomeCoalesced, false, 0},
+		// NOTE(happens): The longer of both windows decides when
+		// the job is run
+		{"debounce in cooldown", hookData{Debounce: 10, Cooldown: 60, CooldownMode: cooldownDefer, LastSuccess: &justNow}, false, outcomeDeferred, false, 60 * time.Second},
+		{"cooldown during debounce", hookData{Debounce: 90, Cooldown: 60, CooldownMode: cooldownDefer, LastSuccess: &justNow}, false, outcomeDeferred, false, 90 * time.Second},
+	}
+
+	for _, c := range cases {
+		q := newTestQueue()
+		deferred := &jobData{
+			ID:        1,
+			Hook:      "foo",
+			Steps:     []jobStep{{Command: "ps:rebuild"}},
+			Callbacks: []jobCallback{{URL: "http://first"}},
+		}
+
+		if c.delayed {
+			q.delayed["foo"] = deferred
+		}
+
+		job := &jobData{
+			ID:         10,
+			Hook:       "foo",
+			Steps:      []jobStep{{Command: "ps:restart"}},
+			RemoteAddr: "10.0.0.1",
+			Callbacks:  []jobCallback{{URL: "http://second"}},
+		}
+
+		before := time.Now()
+		accepted, outcome, _, err := q.accept(job, c.hook)
+		if c.cooldown {
+			if _, ok := err.(cooldownError); !ok {
+				t.Errorf("%s: accept should be rejected, got %v", c.name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: accept failed: %v", c.name, err)
+			continue
+		}
+
+		if outcome != c.want {
+			t.Errorf("%s: outcome = %s, want %s", c.name, outcome, c.want)
+		}
+
+		switch outcome {
+		case outcomeExecuted:
+			if len(q.creating) != 1 || job.NotBefore != nil {
+				t.Errorf("%s: job should be handed off right away", c.name)
+			}
+
+		case outcomeDeferred:
+			if q.delayed["foo"] != job || job.NotBefore == nil {
+				t.Errorf("%s: job should be deferred", c.name)
+				continue
+			}
+
+			delay := job.NotBefore.Sub(before)
+			if delay < c.delay-time.Second || delay > c.delay+time.Second {
+				t.Errorf("%s: job deferred by %s, want %s", c.name, delay, c.delay)
+			}
+
+		case outcomeCoalesced:
+			if accepted != deferred || len(q.creating) != 0 {
+				t.Errorf("%s: trigger should be merged into the deferred job", c.name)
+				continue
+			}
+
+			// NOTE(happens): The deferred job runs with the latest
+			// parameters, and notifies the callbacks of every trigger
+			if deferred.Steps[0].Command != "ps:restart" || deferred.RemoteAddr != "10.0.0.1" {
+				t.Errorf("%s: deferred job was not updated: %+v", c.name, deferred)
+			}
+
+			if len(deferred.Callbacks) != 2 {
+				t.Errorf("%s: deferred job has %d callbacks, want 2", c.name, len(deferred.Callbacks))
+			}
+		}
+	}
+}
+
+func equalIDs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// failureClass describes why an attempt to run a job failed
+type failureClass string
+
+const (
+	// failureConnection means the dokku daemon could not be
+	// reached, so the command was never sent
+	failureConnection failureClass = "connection"
+	// failureCommand means the dokku command itself failed
+	failureCommand failureClass = "command"
+	// failureUnknown means the command was sent, but the daemon
+	// did not respond properly. The command might have run, so
+	// it is never retried.
+	failureUnknown failureClass = "unknown"
+)
+
+const (
+	defaultBackoff           = 5
+	defaultBackoffMultiplier = 2
+	// maxBackoff is the longest time to wait between two attempts,
+	// however many attempts were made before
+	maxBackoff = 10 * time.Minute
+)
+
+// retryPolicy decides whether and when failed attempts are retried.
+// The zero value never retries.
+type retryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int `json:",omitempty"`
+	// Backoff is the number of seconds to wait before the first retry,
+	// which is multiplied by Multiplier for every following retry
+	Backoff    int            `json:",omitempty"`
+	Multiplier float64        `json:",omitempty"`
+	On         []failureClass `json:",omitempty"`
+}
+
+func (p retryPolicy) Enabled() bool {
+	return p.MaxAttempts > 1
+}
+
+// ShouldRetry checks whether another attempt should be made after the
+// given number of attempts have failed with the given class.
+func (p retryPolicy) ShouldRetry(attempts int, class failureClass) bool {
+	if attempts >= p.MaxAttempts {
+		return false
+	}
+
+	on := p.On
+	if len(on) == 0 {
+		on = []failureClass{failureConnection}
+	}
+
+	for _, c := range on {
+		if c == class {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Delay returns the time to wait before the next attempt, after
+// the given number of attempts have failed.
+func (p retryPolicy) Delay(attempts int) time.Duration {
+	backoff := p.Backoff
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	seconds := float64(backoff) * math.Pow(multiplier, float64(attempts-1))
+	if seconds >= maxBackoff.Seconds() {
+		return maxBackoff
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+func (p retryPolicy) String() string {
+	on := []string{}
+	for _, c := range p.On {
+		on = append(on, string(c))
+	}
+
+	if len(on) == 0 {
+		on = append(on, string(failureConnection))
+	}
+
+	backoff := p.Backoff
+	if backoff == 0 {
+		backoff = defaultBackoff
+	}
+
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultBackoffMultiplier
+	}
+
+	return fmt.Sprintf(
+		"attempts=%d (on %s, backoff %ds x%g)",
+		p.MaxAttempts,
+		strings.Join(on, "+"),
+		backoff,
+		multiplier,
+	)
+}
+
+func parseFailureClasses(value string) ([]failureClass, error) {
+	result := []failureClass{}
+	for _, s := range strings.Split(value, ",") {
+		class := failureClass(strings.TrimSpace(s))
+		if class != failureConnection && class != failureCommand {
+			e := fmt.Sprintf("unknown failure class %s, must be connection or command", class)
+			return nil, errors.New(e)
+		}
+
+		result = append(result, class)
+	}
+
+	return result, nil
+}
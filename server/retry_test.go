@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	cases := []struct {
+		policy   retryPolicy
+		attempts int
+		class    failureClass
+		want     bool
+	}{
+		{retryPolicy{}, 1, failureConnection, false},
+		{retryPolicy{MaxAttempts: 3}, 1, failureConnection, true},
+		{retryPolicy{MaxAttempts: 3}, 2, failureConnection, true},
+		{retryPolicy{MaxAttempts: 3}, 3, failureConnection, false},
+		// NOTE(happens): Only connection failures are retried by default
+		{retryPolicy{MaxAttempts: 3}, 1, failureCommand, false},
+		{retryPolicy{MaxAttempts: 3, On: []failureClass{failureCommand}}, 1, failureCommand, true},
+		{retryPolicy{MaxAttempts: 3, On: []failureClass{failureCommand}}, 1, failureConnection, false},
+		{retryPolicy{MaxAttempts: 3, On: []failureClass{failureConnection, failureCommand}}, 1, failureCommand, true},
+		// NOTE(happens): The command might have run, so it's never retried
+		{retryPolicy{MaxAttempts: 3, On: []failureClass{failureConnection, failureCommand}}, 1, failureUnknown, false},
+	}
+
+	for _, c := range cases {
+		if got := c.policy.ShouldRetry(c.attempts, c.class); got != c.want {
+			t.Errorf("%s.ShouldRetry(%d, %s) = %v, want %v", c.policy, c.attempts, c.class, got, c.want)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	cases := []struct {
+		policy   retryPolicy
+		attempts int
+		want     time.Duration
+	}{
+		{retryPolicy{}, 1, 5 * time.Second},
+		{retryPolicy{}, 2, 10 * time.Second},
+		{retryPolicy{}, 3, 20 * time.Second},
+		{retryPolicy{Backoff: 1, Multiplier: 1}, 5, time.Second},
+		{retryPolicy{Backoff: 2, Multiplier: 1.5}, 3, 4500 * time.Millisecond},
+		{retryPolicy{Backoff: 60, Multiplier: 3}, 3, 540 * time.Second},
+		// NOTE(happens): Delays are capped, even if they would overflow
+		{retryPolicy{Backoff: 60, Multiplier: 3}, 4, maxBackoff},
+		{retryPolicy{Backoff: 3600}, 1, maxBackoff},
+		{retryPolicy{Backoff: 5, Multiplier: 10}, 1000, maxBackoff},
+	}
+
+	for _, c := range cases {
+		if got := c.policy.Delay(c.attempts); got != c.want {
+			t.Errorf("%s.Delay(%d) = %s, want %s", c.policy, c.attempts, got, c.want)
+		}
+	}
+}
+
+func TestSetOptionsBackoff(t *testing.T) {
+	cases := []struct {
+		value string
+		ok    bool
+	}{
+		{"1", true},
+		{"30", true},
+		{"0", false},
+		{"-1", false},
+		{"x", false},
+	}
+
+	for _, c := range cases {
+		var hook hookData
+		err := hook.SetOptions([]string{"backoff=" + c.value})
+		if c.ok && err != nil {
+			t.Errorf("SetOptions(backoff=%s) failed: %v", c.value, err)
+		}
+
+		if !c.ok && err == nil {
+			t.Errorf("SetOptions(backoff=%s) should fail", c.value)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// catchUpPolicy decides what happens to scheduled runs that were
+// missed, for example because the server was not running.
+type catchUpPolicy string
+
+const (
+	// catchUpSkip drops all missed runs. This is the default.
+	catchUpSkip catchUpPolicy = "skip"
+	// catchUpOnce runs a single job for all missed runs.
+	catchUpOnce catchUpPolicy = "once"
+	// catchUpAll runs a job for every missed run, up to
+	// catchUpLimit of them.
+	catchUpAll catchUpPolicy = "all"
+)
+
+// catchUpLimit is the maximum number of missed runs that
+// are caught up for a single schedule.
+const catchUpLimit = 10
+
+// hookSchedule is a cron expression that a hook is run on
+type hookSchedule struct {
+	Spec  string
+	Added time.Time
+	// LastRun is the last time the schedule was due, regardless
+	// of whether a job was run for it
+	LastRun *time.Time `json:",omitempty"`
+}
+
+// since returns the time after which runs of the schedule are due.
+func (s hookSchedule) since() time.Time {
+	if s.LastRun != nil {
+		return *s.LastRun
+	}
+
+	return s.Added
+}
+
+// dueRuns returns the times the schedule was due after its last run
+// until now. Only the most recent catchUpLimit+1 times are returned.
+func (s hookSchedule) dueRuns(now time.Time) ([]time.Time, error) {
+	cron, err := parseCron(s.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []time.Time{}
+	for t := cron.Next(s.since()); !t.IsZero() && !t.After(now); t = cron.Next(t) {
+		result = append(result, t)
+		if len(result) > catchUpLimit+1 {
+			result = result[1:]
+		}
+	}
+
+	return result, nil
+}
+
+// addSchedule adds a cron schedule to a hook. Since scheduled jobs
+// can't be given any parameters, the hook must not need any except
+// for the app name.
+func addSchedule(app, name, spec string) error {
+	if _, err := parseCron(spec); err != nil {
+		return err
+	}
+
+	return updateHook(app, name, func(h *hookData) error {
+		for _, arg := range h.Args {
+			if h.needsParam(arg) {
+				e := fmt.Sprintf("hook needs the argument %s, which can't be given by a schedule", arg)
+				return errors.New(e)
+			}
+		}
+
+		for _, s := range h.Schedules {
+			if s.Spec == spec {
+				e := fmt.Sprintf("hook already has the schedule %s", spec)
+				return errors.New(e)
+			}
+		}
+
+		h.Schedules = append(h.Schedules, hookSchedule{Spec: spec, Added: time.Now()})
+		return nil
+	})
+}
+
+// removeSchedule removes a schedule from a hook, either by its
+// cron expression or by its number in the list of schedules.
+func removeSchedule(app, name, spec string) error {
+	return updateHook(app, name, func(h *hookData) error {
+		for i, s := range h.Schedules {
+			if s.Spec == spec || strconv.Itoa(i+1) == spec {
+				h.Schedules = append(h.Schedules[:i], h.Schedules[i+1:]...)
+				return nil
+			}
+		}
+
+		e := fmt.Sprintf("hook has no schedule %s", spec)
+		return errors.New(e)
+	})
+}
+
+// runSchedules checks all schedules at the start of every minute. The
+// first check runs right away, to catch up on runs that were missed
+// while the server was not running.
+func runSchedules() {
+	for {
+		checkSchedules(time.Now())
+
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		time.Sleep(next.Sub(now))
+	}
+}
+
+// scheduledHook is a hook of an enabled app that has schedules
+type scheduledHook struct {
+	app  string
+	hook hookData
+}
+
+// checkSchedules starts jobs for all schedules that are due.
+func checkSchedules(now time.Time) {
+	hooks := []scheduledHook{}
+
+	_ = hookStorage.View(func(tx *bolt.Tx) error {
+		enabled := tx.Bucket([]byte(enabledBucket))
+
+		return tx.ForEach(func(bucket []byte, b *bolt.Bucket) error {
+			if !strings.HasPrefix(string(bucket), "app/") {
+				return nil
+			}
+
+			app := strings.TrimPrefix(string(bucket), "app/")
+			if raw := enabled.Get([]byte(app)); raw == nil || string(raw) != "" {
+				return nil
+			}
+
+			return b.ForEach(func(k []byte, v []byte) error {
+				var hook hookData
+				if err := json.Unmarshal(v, &hook); err != nil {
+					return nil
+				}
+
+				if len(hook.Schedules) > 0 {
+					hooks = append(hooks, scheduledHook{app, hook})
+				}
+
+				return nil
+			})
+		})
+	})
+
+	for _, s := range hooks {
+		for _, schedule := range s.hook.Schedules {
+			runSchedule(s.app, s.hook, schedule, now)
+		}
+	}
+}
+
+// runSchedule starts the jobs that are due for a single schedule,
+// according to the catch-up policy of the hook.
+func runSchedule(app string, hook hookData, schedule hookSchedule, now time.Time) {
+	due, err := schedule.dueRuns(now)
+	if err != nil {
+		fmt.Printf("skipping schedule of %s/%s: %v\n", app, hook.Name, err)
+		return
+	}
+
+	if len(due) == 0 {
+		return
+	}
+
+	// NOTE(happens): A run is only on time if it is due in the
+	// current minute, everything before that was missed
+	latest := due[len(due)-1]
+	onTime := now.Sub(latest) < time.Minute
+	missed := len(due)
+	if onTime {
+		missed--
+	}
+
+	if missed > 0 {
+		fmt.Printf("schedule %s of %s/%s missed %d run(s)\n", schedule.Spec, app, hook.Name, missed)
+	}
+
+	runs := 0
+	if onTime {
+		runs = 1
+	}
+
+	switch hook.catchUp() {
+	case catchUpAll:
+		if missed > catchUpLimit {
+			missed = catchUpLimit
+		}
+
+		runs += missed
+	case catchUpOnce:
+		// NOTE(happens): The run that is on time
+		// covers the missed ones as well
+		if missed > 0 {
+			runs = 1
+		}
+	}
+
+	err = updateHook(app, hook.Name, func(h *hookData) error {
+		for i := range h.Schedules {
+			if h.Schedules[i].Spec == schedule.Spec {
+				h.Schedules[i].LastRun = &latest
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		fmt.Printf("unable to update schedule of %s/%s: %v\n", app, hook.Name, err)
+		return
+	}
+
+	for i := 0; i < runs; i++ {
+		params := map[string]string{"#app": app}
+		job, _, err := startJob(app, hook, params, jobTrigger{Source: sourceSchedule})
+		if err != nil {
+			fmt.Printf("unable to run schedule of %s/%s: %v\n", app, hook.Name, err)
+			continue
+		}
+
+		fmt.Printf("started job %d for schedule %s of %s/%s\n", job.ID, schedule.Spec, app, hook.Name)
+	}
+}
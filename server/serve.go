@@ -9,7 +9,10 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/go-chi/chi"
@@ -19,10 +22,131 @@ import (
 type ctxKey string
 
 const (
-	ctxApp  ctxKey = "app"
-	ctxHook ctxKey = "hook"
+	ctxApp    ctxKey = "app"
+	ctxHook   ctxKey = "hook"
+	ctxSecret ctxKey = "secret"
+	// ctxBodySecret is set if the secret was sent as the request body
+	ctxBodySecret ctxKey = "body-secret"
 )
 
+const (
+	// streamKeepAlive is the interval in which comments are sent on
+	// idle event streams, so that proxies don't close them
+	streamKeepAlive = 15 * time.Second
+
+	// defaultMaxWait is used for synchronous hooks that
+	// don't specify how long to wait for
+	defaultMaxWait = 5 * time.Minute
+
+	// responseOutputLimit is the maximum number of bytes of command
+	// output that will be included in a response
+	responseOutputLimit = 16 * 1024
+)
+
+// reservedParams are query params that control how a hook is
+// executed, and won't be passed to the command template.
+var reservedParams = map[string]bool{
+	"wait":         true,
+	"max_wait":     true,
+	"callback_url": true,
+	"dry_run":      true,
+	"secret":       true,
+}
+
+// secretHeader can be used to pass the secret instead of the request
+// body, which is necessary for requests that don't have a body. The
+// secret query param can be used for the same reason, by callers
+// that can't set headers.
+const secretHeader = "X-Webhooks-Secret"
+
+// gitlabTokenHeader is the header gitlab sends the secret token of a
+// webhook in, so it's accepted the same way as the secret header.
+const gitlabTokenHeader = "X-Gitlab-Token"
+
+// callbackHeader can be used instead of the callback_url query param
+// to pass an url that is notified once the job is done.
+const callbackHeader = "X-Webhooks-Callback-Url"
+
+// jobResponse describes the state of a job to http clients
+type jobResponse struct {
+	ID      uint64    `json:"id"`
+	App     string    `json:"app,omitempty"`
+	Hook    string    `json:"hook,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Parent  uint64    `json:"parent,omitempty"`
+	RetryOf uint64    `json:"retry_of,omitempty"`
+	Status  jobStatus `json:"status"`
+	// Outcome is only set in response to a trigger
+	Outcome   triggerOutcome `json:"outcome,omitempty"`
+	Created   *time.Time     `json:"created,omitempty"`
+	Scheduled *time.Time     `json:"scheduled,omitempty"`
+	Started   *time.Time     `json:"started,omitempty"`
+	Finished  *time.Time     `json:"finished,omitempty"`
+	Duration  float64        `json:"duration"`
+	Steps     []stepResponse `json:"steps,omitempty"`
+	Output    string         `json:"output,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	// Variables contains the builtin variables of the job
+	Variables map[string]string `json:"variables,omitempty"`
+}
+
+// stepResponse describes a single step of a job. The command is left
+// out, since it may contain parameters that were not sent by the caller.
+type stepResponse struct {
+	Always   bool       `json:"always,omitempty"`
+	Status   jobStatus  `json:"status,omitempty"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Output   string     `json:"output,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// renderResponse contains the commands that a hook would
+// run, in response to a dry run.
+type renderResponse struct {
+	DryRun   bool     `json:"dry_run"`
+	Commands []string `json:"commands"`
+	// Argv contains the arguments of every command
+	Argv [][]string `json:"argv"`
+}
+
+func newJobResponse(job *jobData) jobResponse {
+	steps := []stepResponse{}
+	for _, step := range job.Steps {
+		steps = append(steps, stepResponse{
+			Always:   step.Always,
+			Status:   step.Status,
+			Started:  step.Started,
+			Finished: step.Finished,
+			Output:   tail(step.Output, responseOutputLimit),
+			Error:    step.Error,
+		})
+	}
+
+	return jobResponse{
+		ID:        job.ID,
+		App:       job.App,
+		Hook:      job.Hook,
+		Source:    job.Source,
+		Parent:    job.ParentID,
+		RetryOf:   job.RetryOf,
+		Status:    job.Status,
+		Created:   &job.Created,
+		Scheduled: job.NotBefore,
+		Started:   job.Started,
+		Finished:  job.Finished,
+		Duration:  job.Duration().Seconds(),
+		Steps:     steps,
+		Output:    tail(job.Output, responseOutputLimit),
+		Error:     job.Error,
+		Variables: variablesResponse(job),
+	}
+}
+
+func jobLocation(job *jobData) string {
+	return fmt.Sprintf("/%s/%s/jobs/%d", job.App, job.Hook, job.ID)
+}
+
 func serve() {
 	r := chi.NewRouter()
 	r.Route("/{app}/{hook}", func(r chi.Router) {
@@ -31,6 +155,8 @@ func serve() {
 		r.Use(addHookContext)
 
 		r.Post("/", executeHook)
+		r.Get("/jobs/{id}", showJob)
+		r.Get("/jobs/{id}/stream", streamJob)
 	})
 
 	r.Route("/health", func(r chi.Router) {
@@ -61,18 +187,14 @@ func validateSecret(next http.Handler) http.Handler {
 		ctx := r.Context()
 		app := ctx.Value(ctxApp).(string)
 
-		b, err := ioutil.ReadAll(r.Body)
-		defer r.Body.Close()
+		fromBody := len(paramSecret(r)) == 0
+		pw, err := readSecret(r)
 		if err != nil {
 			http.Error(w, http.StatusText(500), 500)
 			return
 		}
 
-		// TODO(happens): Does it make any difference if we make this
-		// a string here, since it will be used as bytes by bcrypt anyways?
-		pw := string(b)
 		var found string
-
 		err = hookStorage.View(func(tx *bolt.Tx) error {
 			secrets := tx.Bucket([]byte(secretsBucket))
 			if secrets == nil {
@@ -102,24 +224,53 @@ func validateSecret(next http.Handler) http.Handler {
 			return
 		}
 
+		ctx = context.WithValue(ctx, ctxSecret, pw)
+		ctx = context.WithValue(ctx, ctxBodySecret, fromBody)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// paramSecret returns the secret from the secret header or the gitlab
+// token header, or from the secret query param if neither is set.
+// NOTE(happens): The query param leaks the plaintext secret, since the
+// full url ends up in the logs of every proxy in between, and in the
+// delivery logs of services like docker hub. It's only meant for
+// callers that can't set any headers.
+func paramSecret(r *http.Request) string {
+	if secret := r.Header.Get(secretHeader); len(secret) > 0 {
+		return secret
+	}
+
+	if secret := r.Header.Get(gitlabTokenHeader); len(secret) > 0 {
+		return secret
+	}
+
+	return r.URL.Query().Get("secret")
+}
+
+// readSecret returns the secret from the secret header or query param
+// if one of them is set, and the request body otherwise.
+func readSecret(r *http.Request) (string, error) {
+	if secret := paramSecret(r); len(secret) > 0 {
+		return secret, nil
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+
+	// TODO(happens): Does it make any difference if we make this
+	// a string here, since it will be used as bytes by bcrypt anyways?
+	return string(b), nil
+}
+
 func validateApp(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app := chi.URLParam(r, "app")
 
-		enabled := false
-		_ = hookStorage.View(func(tx *bolt.Tx) error {
-			apps := tx.Bucket([]byte(enabledBucket))
-			raw := apps.Get([]byte(app))
-			enabled = raw != nil && string(raw) == ""
-
-			return nil
-		})
-
-		if !enabled {
+		if !appEnabled(app) {
 			// TODO(happens): Explain how to enable hooks?
 			http.Error(w, "hooks are not enabled for this app", 400)
 			return
@@ -137,31 +288,7 @@ func addHookContext(next http.Handler) http.Handler {
 		app := ctx.Value(ctxApp).(string)
 		hook := chi.URLParam(r, "hook")
 
-		var found hookData
-		err := hookStorage.View(func(tx *bolt.Tx) error {
-			appBucketStr := fmt.Sprintf("app/%s", app)
-			appBucket := tx.Bucket([]byte(appBucketStr))
-			if appBucket == nil {
-				e := fmt.Sprintf("app %s does not have any hooks", app)
-				return errors.New(e)
-			}
-
-			foundRaw := appBucket.Get([]byte(hook))
-			if foundRaw == nil {
-				e := fmt.Sprintf("app %s has no hook named %s", app, hook)
-				// TODO(happens): Print available hooks for app?
-				return errors.New(e)
-			}
-
-			err := json.Unmarshal(foundRaw, &found)
-			if err != nil {
-				e := fmt.Sprintf("error reading hook data: %v, data:%v", err, foundRaw)
-				return errors.New(e)
-			}
-
-			return nil
-		})
-
+		found, err := loadHook(app, hook)
 		if err != nil {
 			// TODO(happens): Correct error code and better description
 			http.Error(w, http.StatusText(404), 404)
@@ -179,25 +306,338 @@ func executeHook(w http.ResponseWriter, r *http.Request) {
 	app := ctx.Value(ctxApp).(string)
 
 	query := r.URL.Query()
-	params := make(map[string]string)
+	values := make(map[string]string)
+
+	for k := range query {
+		if reservedParams[k] {
+			continue
+		}
+
+		values[k] = query.Get(k)
+	}
+
+	if len(hook.Mappings) > 0 {
+		// NOTE(happens): Hooks that take parameters from the request
+		// need the body for that, so it can't be used as the secret.
+		// This is only checked after authenticating, so that it
+		// doesn't tell anyone which hooks exist.
+		if ctx.Value(ctxBodySecret).(bool) {
+			e := fmt.Sprintf("this hook reads parameters from the request, pass the secret using the %s header", secretHeader)
+			http.Error(w, e, 400)
+			return
+		}
+
+		body, err := readPayload(r)
+		if err == errPayloadTooLarge {
+			http.Error(w, err.Error(), 413)
+			return
+		}
+
+		if err != nil {
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+
+		if err := mapParams(hook, r, body, values); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	params := hookParams(app, values)
+
+	dryRun := false
+	if dryRunStr := query.Get("dry_run"); len(dryRunStr) > 0 {
+		parsed, err := strconv.ParseBool(dryRunStr)
+		if err != nil {
+			http.Error(w, "dry_run must be a boolean", 400)
+			return
+		}
+
+		dryRun = parsed
+	}
+
+	wait := hook.Wait
+	if waitStr := query.Get("wait"); len(waitStr) > 0 {
+		parsed, err := strconv.ParseBool(waitStr)
+		if err != nil {
+			http.Error(w, "wait must be a boolean", 400)
+			return
+		}
+
+		wait = parsed
+	}
+
+	maxWait := defaultMaxWait
+	if hook.MaxWait > 0 {
+		maxWait = time.Duration(hook.MaxWait) * time.Second
+	}
+
+	if maxWaitStr := query.Get("max_wait"); len(maxWaitStr) > 0 {
+		parsed, err := strconv.Atoi(maxWaitStr)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "max_wait must be a positive number of seconds", 400)
+			return
+		}
+
+		maxWait = time.Duration(parsed) * time.Second
+	}
+
+	trigger := jobTrigger{
+		Source:     sourceHTTP,
+		Secret:     ctx.Value(ctxSecret).(string),
+		RemoteAddr: remoteAddr(r),
+		DeliveryID: deliveryID(r),
+	}
+
+	callbackURL := query.Get("callback_url")
+	if header := r.Header.Get(callbackHeader); len(header) > 0 {
+		callbackURL = header
+	}
+
+	if len(callbackURL) > 0 {
+		parsed, err := parseCallbackURL(callbackURL)
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
 
-	params["#app"] = app
-	for k, _ := range query {
-		key := fmt.Sprintf("#%s", k)
-		params[k] = query.Get(key)
+		trigger.CallbackURL = parsed
+	}
+
+	if dryRun {
+		preview := jobData{
+			App:        app,
+			Hook:       hook.Name,
+			Source:     trigger.Source,
+			RemoteAddr: trigger.RemoteAddr,
+			DeliveryID: trigger.DeliveryID,
+			Created:    time.Now(),
+		}
+
+		steps, err := hook.GetSteps(withVariables(params, preview.Variables()))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		rendered := renderResponse{DryRun: true, Commands: []string{}, Argv: [][]string{}}
+		for _, step := range steps {
+			rendered.Commands = append(rendered.Commands, step.Command)
+			rendered.Argv = append(rendered.Argv, step.Argv)
+		}
+
+		writeJSON(w, 200, rendered)
+		return
+	}
+
+	job, outcome, err := startJob(app, hook, params, trigger)
+	if err == errJobSkipped {
+		http.Error(w, err.Error(), 409)
+		return
+	}
+
+	if err == errQueueFull {
+		retryAfter := int(queueRetryAfter.Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, err.Error(), 503)
+		return
+	}
+
+	if cooldown, ok := err.(cooldownError); ok {
+		retryAfter := int(cooldown.remaining.Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, err.Error(), 429)
+		return
 	}
 
-	cmd, err := hook.GetCmd(params)
 	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
 
-	fmt.Printf("executing command: %s\n", cmd)
-	go sendDokkuCmd(cmd)
+	// NOTE(happens): Once the job has been started, only the fields
+	// that were set before it was handed off are safe to read
+	// until it is done
+	location := jobLocation(job)
+	accepted := jobResponse{
+		ID:        job.ID,
+		Status:    jobQueued,
+		Outcome:   outcome,
+		Scheduled: job.NotBefore,
+	}
+
+	if !wait {
+		w.Header().Set("Location", location)
+		writeJSON(w, 202, accepted)
+		return
+	}
+
+	select {
+	case <-job.done:
+	case <-time.After(maxWait):
+		// NOTE(happens): The job keeps running, we just stop waiting.
+		// The stored copy is read to report its current status.
+		if stored, err := getJob(job.ID); err == nil {
+			accepted.Status = stored.Status
+		}
+
+		w.Header().Set("Location", location)
+		writeJSON(w, 202, accepted)
+		return
+	case <-ctx.Done():
+		return
+	}
+
+	result := newJobResponse(job)
+	result.Outcome = outcome
+	writeJSON(w, jobStatusCode(job), result)
+}
+
+// jobStatusCode returns the http status for a job that has finished.
+func jobStatusCode(job *jobData) int {
+	switch job.Status {
+	case jobSucceeded:
+		return 200
+	case jobCanceled:
+		return 409
+	case jobTimedOut:
+		return 504
+	}
+
+	return 500
+}
+
+func showJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hook := ctx.Value(ctxHook).(hookData)
+	app := ctx.Value(ctxApp).(string)
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job id", 400)
+		return
+	}
+
+	job, err := getJob(id)
+	if err != nil || job.App != app || job.Hook != hook.Name {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	writeJSON(w, 200, newJobResponse(job))
+}
+
+// streamJob sends the output and status changes of a job as server-sent
+// events until it is done. Output is sent line by line as "output"
+// events, status changes as "status" events and the final state of
+// the job as a single "end" event.
+func streamJob(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	hook := ctx.Value(ctxHook).(hookData)
+	app := ctx.Value(ctxApp).(string)
+
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid job id", 400)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", 500)
+		return
+	}
+
+	events, unwatch := watchJob(id)
+	defer unwatch()
+
+	job, err := getJob(id)
+	if err != nil || job.App != app || job.Hook != hook.Name {
+		http.Error(w, http.StatusText(404), 404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(200)
+
+	for _, ev := range jobBacklog(job) {
+		writeJobEvent(w, ev)
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for !job.Done() {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				// NOTE(happens): The channel is only closed after
+				// the final state of the job was saved
+				job, err = getJob(id)
+				if err != nil || !job.Done() {
+					return
+				}
+
+				continue
+			}
+
+			writeJobEvent(w, ev)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-ctx.Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+
+	writeEvent(w, "end", newJobResponse(job))
+	flusher.Flush()
+}
+
+func writeJobEvent(w http.ResponseWriter, ev jobEvent) {
+	if len(ev.Output) == 0 {
+		writeEvent(w, "status", ev)
+		return
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(ev.Output, "\n"), "\n") {
+		fmt.Fprintf(w, "event: output\ndata: %s\n\n", line)
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, v interface{}) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(encoded)
+}
+
+// tail returns at most the last n bytes of s.
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
 
-	w.WriteHeader(202)
-	w.Write([]byte(http.StatusText(202)))
+	return s[len(s)-n:]
 }
 
 func reportHealth(w http.ResponseWriter, r *http.Request) {
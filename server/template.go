@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// templatePart is either literal text, or the name of a parameter
+// including its leading #
+type templatePart struct {
+	Text  string
+	Param string
+}
+
+// template is a single argument of a command, split into the
+// literal text and the parameters it contains
+type template []templatePart
+
+// isParamChar checks whether c can be part of a parameter name.
+func isParamChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '_' || c == '.'
+}
+
+// validParamName checks whether name, without its leading #,
+// can be used as the name of a parameter.
+func validParamName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isParamChar(name[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseTemplate reads a template. Parameters are written as #{name},
+// or as #name which takes all following characters that can be part
+// of a name. ## is a literal #, as is a # that isn't followed by a
+// name.
+func parseTemplate(s string) (template, error) {
+	result := template{}
+	var text strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '#' || i+1 >= len(s) {
+			text.WriteByte(s[i])
+			continue
+		}
+
+		var name string
+		switch next := s[i+1]; {
+		case next == '#':
+			text.WriteByte('#')
+			i++
+			continue
+		case next == '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				e := fmt.Sprintf("unterminated parameter in %s, write ## for a literal #", s)
+				return nil, errors.New(e)
+			}
+
+			name = s[i+2 : i+2+end]
+			if !validParamName(name) {
+				e := fmt.Sprintf("invalid parameter name #{%s}", name)
+				return nil, errors.New(e)
+			}
+
+			i += end + 2
+		case isParamChar(next):
+			end := i + 1
+			for end < len(s) && isParamChar(s[end]) {
+				end++
+			}
+
+			name = s[i+1 : end]
+			i = end - 1
+		default:
+			text.WriteByte('#')
+			continue
+		}
+
+		if text.Len() > 0 {
+			result = append(result, templatePart{Text: text.String()})
+			text.Reset()
+		}
+
+		result = append(result, templatePart{Param: fmt.Sprintf("#%s", name)})
+	}
+
+	if text.Len() > 0 {
+		result = append(result, templatePart{Text: text.String()})
+	}
+
+	return result, nil
+}
+
+// Params returns the names of all parameters in the template.
+func (t template) Params() []string {
+	result := []string{}
+	for _, part := range t {
+		if len(part.Param) > 0 {
+			result = append(result, part.Param)
+		}
+	}
+
+	return result
+}
+
+// Render substitutes all parameters of the template. Values
+// are inserted as they are, and never substituted again.
+func (t template) Render(values map[string]string) string {
+	var result strings.Builder
+	for _, part := range t {
+		if len(part.Param) > 0 {
+			result.WriteString(values[part.Param])
+			continue
+		}
+
+		result.WriteString(part.Text)
+	}
+
+	return result.String()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	cases := []struct {
+		s    string
+		want template
+	}{
+		{"", template{}},
+		{"plain", template{{Text: "plain"}}},
+		{"#app", template{{Param: "#app"}}},
+		{"#{app}", template{{Param: "#app"}}},
+		{"MSG=#msg", template{{Text: "MSG="}, {Param: "#msg"}}},
+		// NOTE(happens): #name takes every character that can be part
+		// of a name, #{name} ends the name explicitly
+		{"#tag-latest", template{{Param: "#tag-latest"}}},
+		{"#{tag}-latest", template{{Param: "#tag"}, {Text: "-latest"}}},
+		{"#app.#env", template{{Param: "#app."}, {Param: "#env"}}},
+		{"#{app}.#{env}", template{{Param: "#app"}, {Text: "."}, {Param: "#env"}}},
+		{"#app:#env", template{{Param: "#app"}, {Text: ":"}, {Param: "#env"}}},
+		{"#{a}#{b}", template{{Param: "#a"}, {Param: "#b"}}},
+		{"##", template{{Text: "#"}}},
+		{"##app", template{{Text: "#app"}}},
+		{"###app", template{{Text: "#"}, {Param: "#app"}}},
+		{"##{app}", template{{Text: "#{app}"}}},
+		{"issue-#", template{{Text: "issue-#"}}},
+		{"# x", template{{Text: "# x"}}},
+		{"a#/b", template{{Text: "a#/b"}}},
+	}
+
+	for _, c := range cases {
+		got, err := parseTemplate(c.s)
+		if err != nil {
+			t.Errorf("parseTemplate(%q) failed: %v", c.s, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("parseTemplate(%q) = %+v, want %+v", c.s, got, c.want)
+		}
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	cases := []string{
+		"#{",
+		"#{app",
+		"#{}",
+		"#{bad name}",
+		"#{a/b}",
+		"#{#app}",
+		"x-#{app",
+	}
+
+	for _, s := range cases {
+		if _, err := parseTemplate(s); err == nil {
+			t.Errorf("parseTemplate(%q) should fail", s)
+		}
+	}
+}
+
+func TestTemplateRender(t *testing.T) {
+	values := map[string]string{
+		"#app": "api",
+		"#tag": "#{app}",
+		"#env": "##",
+	}
+
+	cases := []struct {
+		s      string
+		want   string
+		params []string
+	}{
+		{"deploy", "deploy", []string{}},
+		{"#app", "api", []string{"#app"}},
+		{"#{app}-web", "api-web", []string{"#app"}},
+		{"##app=#app", "#app=api", []string{"#app"}},
+		// NOTE(happens): Values are never parsed as templates
+		{"TAG=#tag", "TAG=#{app}", []string{"#tag"}},
+		{"#{env}#{env}", "####", []string{"#env", "#env"}},
+		// NOTE(happens): Parameters without a value are empty
+		{"x#{missing}y", "xy", []string{"#missing"}},
+	}
+
+	for _, c := range cases {
+		tmpl, err := parseTemplate(c.s)
+		if err != nil {
+			t.Errorf("parseTemplate(%q) failed: %v", c.s, err)
+			continue
+		}
+
+		if got := tmpl.Render(values); got != c.want {
+			t.Errorf("%q.Render() = %q, want %q", c.s, got, c.want)
+		}
+
+		if got := tmpl.Params(); !reflect.DeepEqual(got, c.params) {
+			t.Errorf("%q.Params() = %q, want %q", c.s, got, c.params)
+		}
+	}
+}
+
+func TestGetStepsPlaceholders(t *testing.T) {
+	var hook hookData
+	if err := hook.SetCommands("config:set #{app} TAG=#{tag} NOTE=##tag"); err != nil {
+		t.Fatalf("SetCommands failed: %v", err)
+	}
+
+	steps, err := hook.GetSteps(map[string]string{"#app": "api", "#tag": "v1"})
+	if err != nil {
+		t.Fatalf("GetSteps failed: %v", err)
+	}
+
+	want := []string{"config:set", "api", "TAG=v1", "NOTE=#tag"}
+	if len(steps) != 1 || !reflect.DeepEqual(steps[0].Argv, want) {
+		t.Errorf("GetSteps = %+v, want argv %q", steps, want)
+	}
+
+	// NOTE(happens): Placeholders without a value are reported
+	// instead of being rendered empty
+	_, err = hook.GetSteps(map[string]string{"#app": "api", "#other": "x"})
+	problems, ok := err.(paramError)
+	if !ok || len(problems) != 1 {
+		t.Errorf("GetSteps with an unknown placeholder = %v, want one missing parameter", err)
+	}
+
+	// NOTE(happens): Arguments that render empty are left out, since
+	// they can't be sent to the daemon
+	if err := hook.SetCommands("ps:restart #app #proc"); err != nil {
+		t.Fatalf("SetCommands failed: %v", err)
+	}
+
+	steps, err = hook.GetSteps(map[string]string{"#app": "api", "#proc": ""})
+	if err != nil {
+		t.Fatalf("GetSteps failed: %v", err)
+	}
+
+	want = []string{"ps:restart", "api"}
+	if len(steps) != 1 || !reflect.DeepEqual(steps[0].Argv, want) || steps[0].Command != "ps:restart api" {
+		t.Errorf("GetSteps = %+v, want argv %q", steps, want)
+	}
+
+	if err := hook.SetCommands("config:set #{app"); err == nil {
+		t.Errorf("SetCommands with an unterminated placeholder should fail")
+	}
+}
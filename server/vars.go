@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// builtinVar is a variable that the server sets for every job, and
+// that can't be passed as a parameter
+type builtinVar struct {
+	Name        string
+	Description string
+}
+
+var builtinVars = []builtinVar{
+	{"#app", "name of the app"},
+	{"#hook", "name of the hook"},
+	{"#job_id", "id of the job"},
+	{"#timestamp", "time the job was triggered, in RFC 3339 format and UTC"},
+	{"#remote_addr", "ip address of the http client, empty for other sources"},
+	{"#trigger_source", "what triggered the job: http, cli, schedule, chain or retry"},
+	{"#delivery_id", "id of the delivery sent by the provider, empty if there is none"},
+}
+
+// deliveryHeaders contain the unique id of every delivery, as sent
+// by known providers. The first one that is set is used.
+var deliveryHeaders = []string{
+	"X-GitHub-Delivery",
+	"X-Gitea-Delivery",
+	"X-Gogs-Delivery",
+	"X-Gitlab-Event-UUID",
+	"X-Request-UUID",
+	"X-Webhooks-Delivery",
+}
+
+// isBuiltin checks whether a parameter is set by the server.
+func isBuiltin(arg string) bool {
+	for _, v := range builtinVars {
+		if v.Name == arg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteAddr returns the ip address of the client that sent a request.
+// NOTE(happens): The server usually runs behind the proxy of dokku, so
+// the last address in X-Forwarded-For is used, which is the one that
+// proxy has added.
+func remoteAddr(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+		addrs := strings.Split(forwarded, ",")
+		return strings.TrimSpace(addrs[len(addrs)-1])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// deliveryID returns the delivery id of a request, if the
+// provider that sent it has set one.
+func deliveryID(r *http.Request) string {
+	for _, header := range deliveryHeaders {
+		if id := r.Header.Get(header); len(id) > 0 {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// Variables returns the values of all builtin variables for the job.
+func (j jobData) Variables() map[string]string {
+	return map[string]string{
+		"#app":            j.App,
+		"#hook":           j.Hook,
+		"#job_id":         strconv.FormatUint(j.ID, 10),
+		"#timestamp":      j.Created.UTC().Format(time.RFC3339),
+		"#remote_addr":    j.RemoteAddr,
+		"#trigger_source": j.Source,
+		"#delivery_id":    j.DeliveryID,
+	}
+}
+
+// withVariables returns the parameters of a trigger together with
+// the builtin variables, which always take precedence.
+func withVariables(params, vars map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range params {
+		result[k] = v
+	}
+
+	for k, v := range vars {
+		result[k] = v
+	}
+
+	return result
+}
+
+// variablesResponse returns the builtin variables of a job
+// without their leading #, as they are sent in responses.
+func variablesResponse(job *jobData) map[string]string {
+	result := make(map[string]string)
+	for k, v := range job.Variables() {
+		result[strings.TrimPrefix(k, "#")] = v
+	}
+
+	return result
+}
@@ -22,14 +22,30 @@ Additional commands:`
     webhooks:show <app>, List registered webhooks for an app
     webhooks:listen, Start the webhook server
     webhooks:stop, Stop the webhook server
+    webhooks:status, Show how busy the webhook server is
+    webhooks:info, List the variables set by the server
     webhooks:gen-secret <app>, Generate a random secret for an app
     webhooks:set-secret <app> <secret>, Set the secret for an app
     webhooks:enable <app>, Enable all webhooks for an app
     webhooks:disable <app>, Disable all webhooks for an app
-    webhooks:create <app> <name> <command>, Create a webhook
+    webhooks:create <app> <name> <command> [<command>...] [--options], Create a webhook
+    webhooks:update <app> <name> [<command>...] [--options], Update the commands or options of a webhook
     webhooks:delete <app> <name>, Delete a webhook
-    webhooks:trigger <app> <name>, Manually trigger a webhook
-    webhooks:logs <app>, Show webhook activation logs for an app
+    webhooks:trigger <app> <name> [--args <key=value>...], Manually trigger a webhook
+    webhooks:render <app> <name> [--args <key=value>...], Show the commands a webhook would run, without running them
+    webhooks:logs <app> [<name>] [<job-id>] [--follow], Show webhook activation logs for an app
+    webhooks:cancel <app> <job-id>, Cancel a queued or running job
+    webhooks:failed [<app>], List failed jobs that were not acknowledged yet
+    webhooks:retry <job-id>, Run the commands of a failed job again
+    webhooks:ack <job-id>, Dismiss a failed job
+    webhooks:prune [<app>], Remove old jobs from the history of an app, or of all apps
+    webhooks:schedule:add <app> <name> <schedule>, Run a webhook on a cron schedule
+    webhooks:schedule:remove <app> <name> <schedule>, Remove a cron schedule from a webhook
+    webhooks:schedule:list <app> <name>, List the cron schedules of a webhook
+    webhooks:notify:add <app> <name> <webhook|slack|email> <target> [--hook <name>] [--on <events>] [--message <text>], Send notifications about jobs
+    webhooks:notify:remove <app> <name>, Remove a notifier from an app
+    webhooks:notify:list <app>, List the notifiers of an app
+    webhooks:notify:test <app> <name>, Send a test notification
 `
 )
 
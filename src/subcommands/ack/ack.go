@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+func main() {
+	args := os.Args[2:]
+	webhooks.ExpectArgs(args, "job-id")
+	id := args[0]
+	res, err := webhooks.SendCmd(webhooks.CmdAck, id)
+	webhooks.PrintResult(res, err)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+func main() {
+	args := os.Args[2:]
+	webhooks.ExpectArgs(args, "app", "job-id")
+	app, id := args[0], args[1]
+	res, err := webhooks.SendCmd(webhooks.CmdCancel, app, id)
+	webhooks.PrintResult(res, err)
+}
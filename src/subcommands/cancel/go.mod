@@ -0,0 +1,8 @@
+module github.com/happenslol/dokku-webhooks/subcommands/cancel
+
+go 1.12
+
+require (
+	github.com/happenslol/dokku-webhooks v0.0.0-20190501183003-8ada6c39091d
+	github.com/saibing/bingo v0.0.0-20190331051950-76bcd777316d // indirect
+)
@@ -2,14 +2,22 @@ package main
 
 import (
 	"os"
+	"strings"
 
+	dokku "github.com/dokku/dokku/plugins/common"
 	webhooks "github.com/happenslol/dokku-webhooks"
 )
 
 func main() {
-	args := os.Args[2:]
-	webhooks.ExpectArgs(args, "app", "hook", "command")
-	app, hook, command := args[0], args[1], args[2]
-	res, err := webhooks.SendCmd(webhooks.CmdCreate, app, hook, command)
+	fs := webhooks.HookFlags("create")
+	args := webhooks.ParseFlags(fs, os.Args[2:])
+	if len(args) < 3 {
+		dokku.LogFail("Expected: <app> <hook> <command> [<command>...]")
+	}
+
+	// NOTE(happens): Every additional command is another step
+	steps := strings.Join(args[2:], webhooks.StepSeparator)
+	cmdArgs := append([]string{args[0], args[1], steps}, webhooks.HookOptions(fs)...)
+	res, err := webhooks.SendCmd(webhooks.CmdCreate, cmdArgs...)
 	webhooks.PrintResult(res, err)
 }
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	dokku "github.com/dokku/dokku/plugins/common"
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+func main() {
+	args := os.Args[2:]
+	if len(args) > 1 {
+		dokku.LogFail(fmt.Sprintf("Unexpected argument(s): %v", args))
+	}
+
+	res, err := webhooks.SendCmd(webhooks.CmdFailed, args...)
+	webhooks.PrintResult(res, err)
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+func main() {
+	args := os.Args[2:]
+	webhooks.ExpectArgs(args)
+
+	res, err := webhooks.SendCmd(webhooks.CmdInfo)
+	webhooks.PrintResult(res, err)
+}
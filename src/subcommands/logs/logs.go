@@ -1,15 +1,38 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"os"
 
+	dokku "github.com/dokku/dokku/plugins/common"
 	webhooks "github.com/happenslol/dokku-webhooks"
 )
 
 func main() {
-	args := os.Args[2:]
-	webhooks.ExpectArgs(args, "app")
-	app := args[0]
-	res, err := webhooks.SendCmd(webhooks.CmdLogs, app)
-	webhooks.PrintResult(res, err)
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := fs.Bool("follow", false, "stream the output of a job until it is done")
+	args := webhooks.ParseFlags(fs, os.Args[2:])
+	webhooks.ExpectArgs(args, "app", "hook", "job-id")
+
+	if !*follow {
+		res, err := webhooks.SendCmd(webhooks.CmdLogs, args...)
+		webhooks.PrintResult(res, err)
+		return
+	}
+
+	if len(args) < 2 {
+		dokku.LogFail("Expected: <app> <hook> [<job-id>] --follow")
+	}
+
+	res, err := webhooks.StreamCmd(webhooks.CmdFollow, func(part string) {
+		fmt.Println(part)
+	}, args...)
+
+	if err != nil {
+		fmt.Print(err)
+		os.Exit(1)
+	}
+
+	fmt.Println(res)
 }
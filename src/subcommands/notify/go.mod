@@ -0,0 +1,9 @@
+module github.com/happenslol/dokku-webhooks/subcommands/notify
+
+go 1.12
+
+require (
+	github.com/dokku/dokku v0.15.5
+	github.com/happenslol/dokku-webhooks v0.0.0-20190501183003-8ada6c39091d
+	github.com/saibing/bingo v0.0.0-20190331051950-76bcd777316d // indirect
+)
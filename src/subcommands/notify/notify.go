@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	dokku "github.com/dokku/dokku/plugins/common"
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+func main() {
+	cmd := os.Args[1]
+
+	switch cmd {
+	case "webhooks:notify:add":
+		fs := flag.NewFlagSet("notify:add", flag.ExitOnError)
+		fs.String("hook", "", "only notify about jobs of this hook")
+		fs.String("on", "failure,recovery", "comma separated events to notify about: failure, success, recovery")
+		fs.String("message", "", "message to send instead of the default, which can contain variables")
+		args := webhooks.ParseFlags(fs, os.Args[2:])
+		webhooks.ExpectArgs(args, "app", "name", "channel", "target")
+		if len(args) < 4 {
+			dokku.LogFail("Expected: <app> <name> <webhook|slack|email> <target>")
+		}
+
+		cmdArgs := append(args, webhooks.HookOptions(fs)...)
+		res, err := webhooks.SendCmd(webhooks.CmdNotifyAdd, cmdArgs...)
+		webhooks.PrintResult(res, err)
+	case "webhooks:notify:remove", "webhooks:notify:test":
+		args := os.Args[2:]
+		webhooks.ExpectArgs(args, "app", "name")
+		if len(args) < 2 {
+			dokku.LogFail("Expected: <app> <name>")
+		}
+
+		t := webhooks.CmdNotifyRemove
+		if cmd == "webhooks:notify:test" {
+			t = webhooks.CmdNotifyTest
+		}
+
+		res, err := webhooks.SendCmd(t, args...)
+		webhooks.PrintResult(res, err)
+	case "webhooks:notify:list":
+		args := os.Args[2:]
+		webhooks.ExpectArgs(args, "app")
+
+		res, err := webhooks.SendCmd(webhooks.CmdNotifyList, args...)
+		webhooks.PrintResult(res, err)
+	default:
+		dokku.LogFail("Expected one of webhooks:notify:add, webhooks:notify:remove, webhooks:notify:list, webhooks:notify:test")
+	}
+}
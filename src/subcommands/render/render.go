@@ -0,0 +1,24 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	dokku "github.com/dokku/dokku/plugins/common"
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+func main() {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	var params webhooks.ArgsFlag
+	fs.Var(&params, "args", "parameter for the command as key=value, can be repeated")
+	args := webhooks.ParseFlags(fs, os.Args[2:])
+	webhooks.ExpectArgs(args, "app", "hook")
+	if len(args) < 2 {
+		dokku.LogFail("Expected: <app> <hook>")
+	}
+
+	cmdArgs := append(args, params...)
+	res, err := webhooks.SendCmd(webhooks.CmdRender, cmdArgs...)
+	webhooks.PrintResult(res, err)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+
+	dokku "github.com/dokku/dokku/plugins/common"
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+func main() {
+	cmd := os.Args[1]
+	args := os.Args[2:]
+
+	switch cmd {
+	case "webhooks:schedule:add", "webhooks:schedule:remove":
+		webhooks.ExpectArgs(args, "app", "hook", "schedule")
+		if len(args) < 3 {
+			dokku.LogFail("Expected: <app> <hook> <schedule>")
+		}
+
+		t := webhooks.CmdScheduleAdd
+		if cmd == "webhooks:schedule:remove" {
+			t = webhooks.CmdScheduleRemove
+		}
+
+		res, err := webhooks.SendCmd(t, args...)
+		webhooks.PrintResult(res, err)
+	case "webhooks:schedule:list":
+		webhooks.ExpectArgs(args, "app", "hook")
+		if len(args) < 2 {
+			dokku.LogFail("Expected: <app> <hook>")
+		}
+
+		res, err := webhooks.SendCmd(webhooks.CmdScheduleList, args...)
+		webhooks.PrintResult(res, err)
+	default:
+		dokku.LogFail("Expected one of webhooks:schedule:add, webhooks:schedule:remove, webhooks:schedule:list")
+	}
+}
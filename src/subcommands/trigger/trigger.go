@@ -1,15 +1,24 @@
 package main
 
 import (
+	"flag"
 	"os"
 
+	dokku "github.com/dokku/dokku/plugins/common"
 	webhooks "github.com/happenslol/dokku-webhooks"
 )
 
 func main() {
-	args := os.Args[2:]
+	fs := flag.NewFlagSet("trigger", flag.ExitOnError)
+	var params webhooks.ArgsFlag
+	fs.Var(&params, "args", "parameter for the command as key=value, can be repeated")
+	args := webhooks.ParseFlags(fs, os.Args[2:])
 	webhooks.ExpectArgs(args, "app", "hook")
-	app, hook := args[0], args[1]
-	res, err := webhooks.SendCmd(webhooks.CmdTrigger, app, hook)
+	if len(args) < 2 {
+		dokku.LogFail("Expected: <app> <hook>")
+	}
+
+	cmdArgs := append(args, params...)
+	res, err := webhooks.SendCmd(webhooks.CmdTrigger, cmdArgs...)
 	webhooks.PrintResult(res, err)
 }
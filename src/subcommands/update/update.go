@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	dokku "github.com/dokku/dokku/plugins/common"
+	webhooks "github.com/happenslol/dokku-webhooks"
+)
+
+func main() {
+	fs := webhooks.HookFlags("update")
+	args := webhooks.ParseFlags(fs, os.Args[2:])
+	if len(args) < 2 {
+		dokku.LogFail("Expected: <app> <hook> [<command>...]")
+	}
+
+	app, hook := args[0], args[1]
+	steps := strings.Join(args[2:], webhooks.StepSeparator)
+
+	cmdArgs := append([]string{app, hook, steps}, webhooks.HookOptions(fs)...)
+	res, err := webhooks.SendCmd(webhooks.CmdUpdate, cmdArgs...)
+	webhooks.PrintResult(res, err)
+}
@@ -3,6 +3,7 @@ package webhooks
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
@@ -21,7 +22,8 @@ type Cmd struct {
 }
 
 // Response will be sent back from the server when a
-// Cmd is received
+// Cmd is received. Commands that stream their result send
+// any number of partial responses before the final one.
 type Response struct {
 	Status  int    `json:"status"`
 	Content string `json:"content,omitempty"`
@@ -43,6 +45,13 @@ func (r *Response) Fail(err error) {
 	r.Content = err.Error()
 }
 
+// Partial marks the response as an intermediate part of
+// a streamed result.
+func (r *Response) Partial(content string) {
+	r.Status = statusPartial
+	r.Content = content
+}
+
 const (
 	// CmdPing pings the webhooks server to check its health.
 	CmdPing CmdType = iota
@@ -59,7 +68,8 @@ const (
 	// CmdCreate creates a webhook.
 	// * app name
 	// * webhook name
-	// * command template
+	// * command templates, separated by StepSeparator
+	// * (optional) hook options as key=value
 	CmdCreate
 	// CmdDelete deletes a webhook.
 	// * app name
@@ -76,17 +86,99 @@ const (
 	// was called with the correct secret.
 	// * app name
 	// * webhook name
+	// * (optional) parameters as key=value
 	CmdTrigger
-	// CmdLogs returns a list of activations for a specific webhook.
+	// CmdLogs returns a list of activations for an app or a specific
+	// webhook, or the full output of a single activation.
 	// * app name
+	// * (optional) webhook name
+	// * (optional) job id
 	CmdLogs
 	// CmdQuit shuts down the server process.
 	CmdQuit
+
+	// NOTE(happens): Commands are sent by their number, so new commands
+	// are always added at the end to keep older cli binaries working.
+
+	// CmdUpdate changes the command template or options of a webhook.
+	// * app name
+	// * webhook name
+	// * command templates, separated by StepSeparator, unchanged if empty
+	// * (optional) hook options as key=value
+	CmdUpdate
+	// CmdCancel aborts a job that is queued or running.
+	// * app name
+	// * job id
+	CmdCancel
+	// CmdFollow streams the output of a job until it is done.
+	// * app name
+	// * webhook name
+	// * (optional) job id, defaults to the latest job
+	CmdFollow
+	// CmdPrune removes old jobs from the history according to
+	// the retention settings of the server.
+	// * (optional) app name, defaults to all apps
+	CmdPrune
+	// CmdScheduleAdd adds a cron schedule to a webhook.
+	// * app name
+	// * webhook name
+	// * cron expression
+	CmdScheduleAdd
+	// CmdScheduleRemove removes a cron schedule from a webhook.
+	// * app name
+	// * webhook name
+	// * cron expression or its number in the list of schedules
+	CmdScheduleRemove
+	// CmdScheduleList returns all cron schedules of a webhook.
+	// * app name
+	// * webhook name
+	CmdScheduleList
+	// CmdNotifyAdd adds a notifier to an app.
+	// * app name
+	// * notifier name
+	// * channel: webhook, slack or email
+	// * target url or email address
+	// * (optional) notifier options as key=value
+	CmdNotifyAdd
+	// CmdNotifyRemove removes a notifier from an app.
+	// * app name
+	// * notifier name
+	CmdNotifyRemove
+	// CmdNotifyList returns all notifiers of an app.
+	// * app name
+	CmdNotifyList
+	// CmdNotifyTest sends a test notification using a notifier.
+	// * app name
+	// * notifier name
+	CmdNotifyTest
+	// CmdRender returns the commands a webhook would run with the
+	// given parameters, without running them.
+	// * app name
+	// * webhook name
+	// * (optional) parameters as key=value
+	CmdRender
+	// CmdStatus returns the usage of the worker pool and the
+	// number of jobs per app.
+	CmdStatus
+	// CmdFailed returns all failed jobs that were not
+	// acknowledged yet.
+	// * (optional) app name
+	CmdFailed
+	// CmdRetry runs the commands of a failed job again.
+	// * job id
+	CmdRetry
+	// CmdAck acknowledges a failed job.
+	// * job id
+	CmdAck
+	// CmdInfo returns the builtin variables that can be
+	// used in commands and notifications.
+	CmdInfo
 )
 
 const (
 	statusSuccess = 0
 	statusFailure = 1
+	statusPartial = 2
 
 	webhooksDir = "/var/lib/dokku/data/webhooks"
 	cmdSocket   = "/var/lib/dokku/data/webhooks/cmd.sock"
@@ -95,6 +187,12 @@ const (
 // SendCmd sends a message to the command socket and return the response as
 // a string which can be printed out as-is
 func SendCmd(t CmdType, args ...string) (string, error) {
+	return StreamCmd(t, nil, args...)
+}
+
+// StreamCmd works like SendCmd, but calls onPartial with the content of
+// every partial response that is received before the final one.
+func StreamCmd(t CmdType, onPartial func(string), args ...string) (string, error) {
 	if !dokku.DirectoryExists(webhooksDir) {
 		// TODO(happens): Tell user how to enable webhooks
 		// NOTE(happens): The directory won't exist if webhooks haven't
@@ -138,9 +236,20 @@ func SendCmd(t CmdType, args ...string) (string, error) {
 	var res Response
 	de := json.NewDecoder(c)
 
-	if err = de.Decode(&res); err != nil {
-		e := fmt.Sprintf("unable to decode response: %v\n", err)
-		return "", errors.New(e)
+	for {
+		res = Response{}
+		if err = de.Decode(&res); err != nil {
+			e := fmt.Sprintf("unable to decode response: %v\n", err)
+			return "", errors.New(e)
+		}
+
+		if res.Status != statusPartial {
+			break
+		}
+
+		if onPartial != nil {
+			onPartial(res.Content)
+		}
 	}
 
 	if res.Status != 0 {
@@ -175,6 +284,106 @@ func ExpectArgs(actual []string, expected ...string) {
 	}
 }
 
+// ParseFlags parses the flags in args using the given flag set and
+// returns the remaining positional arguments. Unlike fs.Parse, flags
+// may appear anywhere in between the positional arguments.
+func ParseFlags(fs *flag.FlagSet, args []string) []string {
+	positional := []string{}
+
+	for {
+		// NOTE(happens): The flag set exits on error, so we
+		// can safely ignore this
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// ArgsFlag collects the parameters passed using a
+// repeatable --args key=value flag.
+type ArgsFlag []string
+
+func (a *ArgsFlag) String() string {
+	return strings.Join(*a, " ")
+}
+
+func (a *ArgsFlag) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return errors.New("expected key=value")
+	}
+
+	*a = append(*a, value)
+	return nil
+}
+
+// ListFlag collects the values of a repeatable flag.
+type ListFlag []string
+
+func (l *ListFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *ListFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// StepSeparator separates the commands of a multi-step hook
+// when they are sent to the server as a single argument.
+const StepSeparator = "\n"
+
+// HookFlags returns a flag set containing all options that
+// can be configured for a single webhook.
+func HookFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Bool("wait", false, "respond only after the command has finished")
+	fs.Int("max-wait", 0, "maximum number of seconds to wait for the command")
+	fs.String("concurrency", "queue", "what to do with triggers while the app is busy: allow, queue, skip or replace")
+	fs.Int("debounce", 0, "coalesce all triggers within this many seconds into one job")
+	fs.Int("cooldown", 0, "number of seconds after a successful job in which triggers are not run")
+	fs.String("cooldown-mode", "reject", "what to do with triggers during the cooldown: reject or defer")
+	fs.Int("timeout", 0, "number of seconds after which a running job is aborted")
+	fs.Int("max-attempts", 1, "number of times a failed job is tried before giving up")
+	fs.Int("backoff", 5, "number of seconds to wait before the first retry")
+	fs.Float64("backoff-multiplier", 2, "factor by which the backoff grows after every retry")
+	fs.String("retry-on", "connection", "comma separated failures to retry: connection, command")
+	fs.String("on-restart", "interrupt", "what to do with unfinished jobs when the server restarts: resume or interrupt")
+	fs.String("catch-up", "skip", "what to do with scheduled runs that were missed: skip, once or all")
+	fs.String("on-success", "", "comma separated hooks to trigger after a successful job, as app/hook or hook")
+	fs.String("on-failure", "", "comma separated hooks to trigger after a failed job, as app/hook or hook")
+	fs.String("always", "", "comma separated numbers of steps that run even if an earlier step failed")
+	fs.Var(new(ListFlag), "param", "declare a parameter as name; type=int; enum=a|b; pattern=re; default=a; description=text")
+	fs.Var(new(ListFlag), "remove-param", "remove the declaration of a parameter")
+	fs.Var(new(ListFlag), "map", "take a parameter from the request as name <- $.json.path, form:<field> or header:<name>")
+	fs.Var(new(ListFlag), "remove-map", "take a parameter from the query params again")
+	return fs
+}
+
+// HookOptions returns all flags that were explicitly set on
+// the flag set as key=value pairs that can be sent to the server.
+func HookOptions(fs *flag.FlagSet) []string {
+	result := []string{}
+	fs.Visit(func(f *flag.Flag) {
+		// NOTE(happens): Repeatable flags are sent once per value
+		if list, ok := f.Value.(*ListFlag); ok {
+			for _, value := range *list {
+				result = append(result, fmt.Sprintf("%s=%s", f.Name, value))
+			}
+
+			return
+		}
+
+		result = append(result, fmt.Sprintf("%s=%s", f.Name, f.Value))
+	})
+
+	return result
+}
+
 func PrintResult(res string, err error) {
 	if err != nil {
 		fmt.Printf("an error occurred:\n%s\n", err)